@@ -0,0 +1,194 @@
+package apiv1
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// AdminMiddleware middleware
+// Only manager-level admins get through, every admin request is audited
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		playerName := c.GetString("player")
+		if !auth.IsAdmin(playerName) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"result": http.StatusForbidden,
+				"error":  "admins only",
+			})
+			return
+		}
+
+		storage.AuditWrite(playerName, "admin:"+c.Request.Method, c.Request.URL.Path)
+		c.Next()
+	}
+}
+
+// adminServers lists every server, including deleted ones
+func adminServers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"result":  http.StatusOK,
+		"error":   "",
+		"servers": server.AllServers(),
+	})
+}
+
+// listAdmins lists the manager-level admins
+func listAdmins(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"admins": auth.Admins(),
+	})
+}
+
+// editAdmins adds or removes a manager-level admin
+func editAdmins(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.AdminEdit
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	var err error
+	var what = "admin:admins:add"
+	if formData.Remove {
+		what = "admin:admins:remove"
+		err = auth.RemoveAdmin(formData.PlayerName)
+	} else {
+		err = auth.AddAdmin(formData.PlayerName)
+	}
+
+	switch err {
+	case nil:
+		success = http.StatusOK
+		storage.AuditWrite(playerName, what, formData.PlayerName)
+	case auth.ErrAlreadyAdmin, auth.ErrNotAdmin, auth.ErrLastAdmin:
+		success = http.StatusBadRequest
+	default:
+		log.Printf("admin edit error: %s", err.Error())
+		err = fmt.Errorf("Unable to change admins")
+	}
+
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+		"admins": auth.Admins(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
+// listAccounts lists the local accounts and identity links
+func listAccounts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"result":   http.StatusOK,
+		"error":    "",
+		"accounts": auth.Accounts(),
+		"links":    auth.Links(),
+	})
+}
+
+// editAccount creates, changes or removes a local account
+func editAccount(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.Account
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	var err error
+	var what = "admin:accounts:edit"
+	if formData.Remove {
+		what = "admin:accounts:remove"
+		err = auth.DeleteAccount(formData.Username)
+	}
+	for err == nil && !formData.Remove {
+		if formData.Password != "" {
+			err = auth.SetPassword(formData.Username, formData.Password)
+		} else if !auth.AccountExists(formData.Username) {
+			err = auth.ErrNoSuchAccount
+		}
+		if err != nil || formData.PlayerName == "" {
+			break
+		}
+		err = auth.LinkIdentity("local:"+formData.Username, formData.PlayerName)
+		break
+	}
+
+	switch err {
+	case nil:
+		success = http.StatusOK
+		storage.AuditWrite(playerName, what, formData.Username)
+	case auth.ErrBadUsername, auth.ErrShortPassword, auth.ErrNoSuchAccount:
+		success = http.StatusBadRequest
+	default:
+		log.Printf("account edit error: %s", err.Error())
+		err = fmt.Errorf("Unable to change the account")
+	}
+
+	var data = gin.H{
+		"result":   success,
+		"error":    "",
+		"accounts": auth.Accounts(),
+		"links":    auth.Links(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
+// editLink links an identity (e.g. oidc:subject) to a Minecraft player, or unlinks it
+func editLink(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.Link
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	var err error
+	var what = "admin:links:add"
+	if formData.Remove {
+		what = "admin:links:remove"
+		err = auth.UnlinkIdentity(formData.Identity)
+	} else {
+		err = auth.LinkIdentity(formData.Identity, formData.PlayerName)
+	}
+
+	switch err {
+	case nil:
+		success = http.StatusOK
+		storage.AuditWrite(playerName, what, formData.Identity+" "+formData.PlayerName)
+	case auth.ErrBadIdentity, auth.ErrNotLinkedIdentity:
+		success = http.StatusBadRequest
+	default:
+		log.Printf("link edit error: %s", err.Error())
+		err = fmt.Errorf("Unable to change the link")
+	}
+
+	var data = gin.H{
+		"result":   success,
+		"error":    "",
+		"accounts": auth.Accounts(),
+		"links":    auth.Links(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
@@ -0,0 +1,137 @@
+package apiv1
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/events"
+	"github.com/jlmeeker/mcmanager/proxy"
+	"github.com/jlmeeker/mcmanager/server"
+)
+
+// keepAlive is how often a comment is sent on an idle event stream so proxies don't close it
+var keepAlive = 30 * time.Second
+
+// sharedView is the status view of a server built for one event, shared by every stream
+type sharedView struct {
+	once sync.Once
+	at   time.Time
+	view server.WebView
+}
+
+// sharedViews holds the latest shared view per server ID
+var (
+	sharedViews   = make(map[string]*sharedView)
+	sharedViewsMu sync.Mutex
+)
+
+// eventView returns the status view of the server an event is about, asking the
+// server (ping, query and rcon) only once per event however many streams are open
+func eventView(s *server.Server, ev events.Event) server.WebView {
+	sharedViewsMu.Lock()
+	sv, ok := sharedViews[ev.ServerID]
+	if !ok || sv == nil || !sv.at.Equal(ev.Time) {
+		sv = &sharedView{at: ev.Time}
+		sharedViews[ev.ServerID] = sv
+	}
+	sharedViewsMu.Unlock()
+
+	sv.once.Do(func() { sv.view = s.StatusView() })
+	return sv.view
+}
+
+// forgetView drops the shared view of a server that is gone
+func forgetView(serverID string) {
+	sharedViewsMu.Lock()
+	sharedViews[serverID] = nil
+	sharedViewsMu.Unlock()
+}
+
+// stillAuthenticated checks the session or API token of a long-running request again,
+// so logging out or revoking a token also ends streams opened with it
+func stillAuthenticated(c *gin.Context) bool {
+	if bearer := bearerToken(c); bearer != "" {
+		_, ok := auth.VerifyAPIToken(bearer, proxy.ClientIP(c.Request))
+		return ok
+	}
+	token, _ := c.Cookie("token")
+	return auth.VerifySession(c.GetString("player"), token)
+}
+
+// eventStream pushes server state changes and job progress to the browser as server-sent events
+// Server events carry the player's current view of the server so the UI doesn't have to re-poll.
+// Removals only go to streams that could see the server.
+func eventStream(c *gin.Context) {
+	playerName := c.GetString("player")
+	// a token scoped to some servers only sees their events
+	scoped := !tokenAllowsServer(c, "")
+
+	// seen are the servers this stream's player could see, they are told when one goes away
+	var seen = make(map[string]bool)
+	for id := range server.ServersWithPlayer(playerName) {
+		if tokenAllowsServer(c, id) {
+			seen[id] = true
+		}
+	}
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-time.After(keepAlive):
+			if !stillAuthenticated(c) {
+				return false
+			}
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		case ev, ok := <-ch:
+			if !ok || !stillAuthenticated(c) {
+				return false
+			}
+
+			if ev.ServerID != "" && !tokenAllowsServer(c, ev.ServerID) {
+				return true
+			}
+
+			if ev.Type == events.JobProgress {
+				if ev.Player == playerName && (ev.ServerID != "" || !scoped) {
+					c.SSEvent(ev.Type, ev)
+				}
+				return true
+			}
+
+			if ev.ServerID == "" {
+				return true
+			}
+
+			var payload = gin.H{"event": ev}
+			s, ok := server.Servers.Get(ev.ServerID)
+			if ev.Type == events.ServerRemoved || !ok {
+				forgetView(ev.ServerID)
+			}
+			_, visible := server.ServersWithPlayer(playerName)[ev.ServerID]
+			switch {
+			case ev.Type != events.ServerRemoved && ok && visible:
+				payload["server"] = s.PlayerView(eventView(s, ev), playerName)
+				seen[ev.ServerID] = true
+			case seen[ev.ServerID]:
+				payload["removed"] = true
+				seen[ev.ServerID] = false
+			default:
+				return true
+			}
+
+			c.SSEvent(ev.Type, payload)
+			return true
+		}
+	})
+}
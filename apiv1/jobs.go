@@ -0,0 +1,65 @@
+package apiv1
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/jobs"
+	"github.com/jlmeeker/mcmanager/server"
+)
+
+// serverJob runs fn in the background with the server's lifecycle lock held,
+// progress and command output from the server end up in the job
+func serverJob(kind, serverID, playerName string, fn func(s *server.Server) error) jobs.Job {
+	return jobs.Run(kind, serverID, playerName, func(t *jobs.Task) error {
+		err := server.Servers.Do(serverID, func(s *server.Server) error {
+			s.SetReporter(t)
+			defer s.SetReporter(nil)
+			return fn(s)
+		})
+		if err != nil {
+			log.Printf("%s error (%s): %s", kind, serverID, err.Error())
+			t.Logf("%s failed: %s", kind, err.Error())
+			return fmt.Errorf("Failed to %s the server", kind)
+		}
+		return nil
+	})
+}
+
+// getJob returns the status, progress and logs of one of the player's jobs
+func getJob(c *gin.Context) {
+	playerName := c.GetString("player")
+
+	job, err := jobs.Get(c.Param("id"))
+	if err != nil || job.Owner != playerName || !tokenAllowsServer(c, job.ServerID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"result": http.StatusNotFound,
+			"error":  "job not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"job":    job,
+	})
+}
+
+// listJobs returns all of the player's jobs, newest first
+func listJobs(c *gin.Context) {
+	playerName := c.GetString("player")
+	var list = []jobs.Job{}
+	for _, job := range jobs.List(playerName) {
+		if tokenAllowsServer(c, job.ServerID) {
+			list = append(list, job)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"jobs":   list,
+	})
+}
@@ -0,0 +1,244 @@
+package apiv1
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/proxy"
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/stats"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// pageRe limits where a redirect login may send the browser back to
+var pageRe = regexp.MustCompile(`^[a-z]*$`)
+
+// loginProviders lists the enabled login providers for the login form
+func loginProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"result":    http.StatusOK,
+		"error":     "",
+		"providers": auth.Providers(),
+	})
+}
+
+// loginPassword logs in with a local username and password
+func loginPassword(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.Login
+
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	var id auth.Identity
+	var err error
+	p, ok := auth.GetProvider("local")
+	if !ok {
+		success = http.StatusNotFound
+		err = fmt.Errorf("password login is not enabled")
+	}
+	for err == nil {
+		if err = loginThrottle(c, formData.Username); err != nil {
+			break
+		}
+		id, err = p.(auth.PasswordProvider).Login(formData.Username, formData.Password)
+		if err != nil {
+			break
+		}
+		auth.LoginSucceeded(proxy.ClientIP(c.Request), formData.Username)
+		err = finishLogin(c, id)
+		break
+	}
+
+	switch err.(type) {
+	case nil:
+		success = http.StatusOK
+	case auth.ThrottleError:
+		success = http.StatusTooManyRequests
+	case auth.NotLinkedError:
+		success = http.StatusForbidden
+		stats.Inc(stats.LoginAttempts, "result", "failure")
+	default:
+		if err == auth.ErrBadLogin {
+			success = http.StatusUnauthorized
+			loginFailed(c, "local", formData.Username, err.Error())
+		} else if success != http.StatusNotFound {
+			log.Printf("password login error: %s", err.Error())
+			err = fmt.Errorf("Unable to log in")
+			stats.Inc(stats.LoginAttempts, "result", "failure")
+		}
+	}
+
+	var data = gin.H{
+		"result":     success,
+		"error":      "",
+		"page":       formData.Page,
+		"playername": id.PlayerName,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
+// loginRedirect sends the browser to the OIDC identity provider
+func loginRedirect(c *gin.Context) {
+	p, ok := auth.GetProvider("oidc")
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := loginThrottle(c, ""); err != nil {
+		loginPage(c, http.StatusTooManyRequests, "", err.Error())
+		return
+	}
+
+	login, err := auth.NewRedirectLogin()
+	if err == nil {
+		var target string
+		target, err = p.(auth.RedirectProvider).AuthURL(login)
+		if err == nil {
+			var page = c.Query("page")
+			if !pageRe.MatchString(page) {
+				page = ""
+			}
+			// Lax, the browser has to send these back when the identity provider redirects here
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie("oidcstate", login.State, 600, "/api/v1/login/oidc", "", secureCookies(c), true)
+			c.SetCookie("oidcnonce", login.Nonce, 600, "/api/v1/login/oidc", "", secureCookies(c), true)
+			c.SetCookie("oidcverifier", login.Verifier, 600, "/api/v1/login/oidc", "", secureCookies(c), true)
+			c.SetCookie("oidcpage", page, 600, "/api/v1/login/oidc", "", secureCookies(c), true)
+			c.Redirect(http.StatusFound, target)
+			return
+		}
+	}
+
+	log.Printf("oidc login error: %s", err.Error())
+	loginPage(c, http.StatusInternalServerError, "", "Unable to start the single sign-on login")
+}
+
+// loginCallback finishes an OIDC login when the identity provider sends the browser back
+func loginCallback(c *gin.Context) {
+	p, ok := auth.GetProvider("oidc")
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var login auth.RedirectLogin
+	login.State, _ = c.Cookie("oidcstate")
+	login.Nonce, _ = c.Cookie("oidcnonce")
+	login.Verifier, _ = c.Cookie("oidcverifier")
+	page, _ := c.Cookie("oidcpage")
+	for _, name := range []string{"oidcstate", "oidcnonce", "oidcverifier", "oidcpage"} {
+		c.SetCookie(name, "", -1, "/api/v1/login/oidc", "", secureCookies(c), true)
+	}
+	if !pageRe.MatchString(page) {
+		page = ""
+	}
+
+	if msg := c.Query("error"); msg != "" {
+		loginFailed(c, "oidc", "", msg)
+		loginPage(c, http.StatusUnauthorized, page, "Login failed: "+msg)
+		return
+	}
+	if login.State == "" || c.Query("state") != login.State {
+		loginFailed(c, "oidc", "", "state mismatch")
+		loginPage(c, http.StatusBadRequest, page, "Login failed: the login expired or was started elsewhere")
+		return
+	}
+
+	var id auth.Identity
+	var err error
+	for err == nil {
+		id, err = p.(auth.RedirectProvider).Exchange(c.Query("code"), login)
+		if err != nil {
+			break
+		}
+		err = finishLogin(c, id)
+		break
+	}
+
+	switch err.(type) {
+	case nil:
+		loginPage(c, http.StatusOK, page, "")
+	case auth.NotLinkedError:
+		stats.Inc(stats.LoginAttempts, "result", "failure")
+		loginPage(c, http.StatusForbidden, page, err.Error())
+	default:
+		loginFailed(c, "oidc", "", err.Error())
+		log.Printf("oidc login error: %s", err.Error())
+		loginPage(c, http.StatusInternalServerError, page, "Login failed")
+	}
+}
+
+// loginPage sends the browser back to the web UI after a redirect login. It is
+// a page (not a redirect) so the browser treats the next request as same-site
+// and sends the new SameSite=Strict cookies.
+func loginPage(c *gin.Context, code int, page, msg string) {
+	var target = "/view/" + page
+	var body string
+	if msg == "" {
+		body = fmt.Sprintf(`<meta http-equiv="refresh" content="0;url=%s"><a href="%s">Continue</a>`, target, target)
+	} else {
+		body = fmt.Sprintf(`<p>%s</p><a href="%s">Back</a>`, html.EscapeString(msg), target)
+	}
+	c.Data(code, "text/html; charset=utf-8", []byte("<!DOCTYPE html><html><body>"+body+"</body></html>"))
+}
+
+// loginThrottle refuses login attempts that come too fast or after too many
+// failures, the reply tells the client when to try again
+func loginThrottle(c *gin.Context, username string) error {
+	ip := proxy.ClientIP(c.Request)
+	err := auth.LoginAllowed(ip, username)
+	if terr, ok := err.(auth.ThrottleError); ok {
+		stats.Inc(stats.LoginAttempts, "result", "throttled")
+		storage.AuditWrite(auditName(username), "login:throttled", ip)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(terr.Wait.Seconds()))))
+	}
+	return err
+}
+
+// loginFailed counts and audits a failed login
+func loginFailed(c *gin.Context, provider, username, reason string) {
+	ip := proxy.ClientIP(c.Request)
+	stats.Inc(stats.LoginAttempts, "result", "failure")
+	storage.AuditWrite(auditName(username), "login:failed:"+provider, ip+" "+reason)
+	if auth.LoginFailed(ip, username) {
+		storage.AuditWrite(auditName(username), "login:locked", ip)
+	}
+}
+
+// auditName is who a login attempt is written down as in the audit log
+func auditName(username string) string {
+	if username == "" {
+		return "-"
+	}
+	return username
+}
+
+// finishLogin starts a session for the player an identity acts as
+func finishLogin(c *gin.Context, id auth.Identity) error {
+	token, _, err := auth.NewSession(id, c.Request.UserAgent(), proxy.ClientIP(c.Request))
+	if err != nil {
+		return err
+	}
+
+	stats.Inc(stats.LoginAttempts, "result", "success")
+	auth.RememberPlayer(id.PlayerName, id.PlayerUUID)
+	setLoginCookies(c, token, id.PlayerName)
+	server.AdoptOwnerUUIDs(id.PlayerName, id.PlayerUUID)
+	auth.AdoptAdminUUID(id.PlayerName)
+	storage.AuditWrite(id.PlayerName, "login:"+id.Provider, id.Subject)
+	return nil
+}
@@ -0,0 +1,83 @@
+package apiv1
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/server"
+)
+
+// roles returns the built-in and custom roles of a server and who has been granted which
+func roles(c *gin.Context) {
+	s, ok := server.Servers.Get(c.Param("serverid"))
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"roles":  s.RolesView(),
+	})
+}
+
+// grantRole gives a player a role on a server, or revokes it when no role is sent
+func grantRole(c *gin.Context) {
+	var formData forms.Grant
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	roleResult(c, server.Servers.Do(c.Param("serverid"), func(s *server.Server) error {
+		if formData.Role == "" {
+			return s.RevokeRole(playerName, formData.PlayerName)
+		}
+		return s.GrantRole(playerName, formData.PlayerName, formData.Role)
+	}))
+}
+
+// defineRole creates, changes or (with no actions) deletes a custom role of a server
+func defineRole(c *gin.Context) {
+	var formData forms.Role
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	roleResult(c, server.Servers.Do(c.Param("serverid"), func(s *server.Server) error {
+		return s.DefineRole(playerName, formData.Role, formData.Actions)
+	}))
+}
+
+// roleResult replies to the role actions, telling the player what was wrong with the request
+func roleResult(c *gin.Context, err error) {
+	var success = http.StatusInternalServerError
+	switch err {
+	case nil:
+		success = http.StatusOK
+	case server.ErrUnknownPlayer, server.ErrUnknownRole, server.ErrRoleName, server.ErrRoleAction,
+		server.ErrGrantToOwner, server.ErrNoRoleToRevoke:
+		success = http.StatusBadRequest
+	case server.ErrRoleTooHigh:
+		success = http.StatusForbidden
+	default:
+		log.Printf("role error: %s", err.Error())
+		err = fmt.Errorf("Unable to change roles")
+	}
+
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
@@ -0,0 +1,208 @@
+package apiv1
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/proxy"
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// authenticateAPIToken lets a request in as the player of an API token
+func authenticateAPIToken(c *gin.Context, bearer string) {
+	t, ok := auth.VerifyAPIToken(bearer, proxy.ClientIP(c.Request))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"result": http.StatusUnauthorized,
+			"error":  "invalid or expired api token",
+		})
+		return
+	}
+	if t.ReadOnly && !safeMethod(c.Request.Method) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"result": http.StatusForbidden,
+			"error":  "read-only api token",
+		})
+		return
+	}
+
+	c.Set("player", t.Player)
+	c.Set("apitoken", t)
+	c.Next()
+}
+
+// SessionOnly middleware
+// Keeps API tokens away from routes that manage logins and tokens
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("apitoken"); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"result": http.StatusForbidden,
+				"error":  "not available to api tokens",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// tokenScope checks a request that isn't about one server against the scope of its API token
+func tokenScope(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if t, ok := c.Get("apitoken"); ok && !t.(auth.APIToken).Allows("", action) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"result": http.StatusForbidden,
+				"error":  "not allowed for this api token",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// tokenAllowsServer reports whether the request's API token (if it has one) covers the server,
+// lists are filtered with it so a token scoped to some servers doesn't see the others
+func tokenAllowsServer(c *gin.Context, serverID string) bool {
+	t, ok := c.Get("apitoken")
+	return !ok || t.(auth.APIToken).AllowsServer(serverID)
+}
+
+// auditWho is who a request is written down as in the audit log, API tokens are named
+func auditWho(c *gin.Context) string {
+	playerName := c.GetString("player")
+	if t, ok := c.Get("apitoken"); ok {
+		return playerName + "/token:" + t.(auth.APIToken).Name
+	}
+	return playerName
+}
+
+// listAPITokens lists the player's API tokens
+func listAPITokens(c *gin.Context) {
+	playerName := c.GetString("player")
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"tokens": auth.PlayerAPITokens(playerName),
+	})
+}
+
+// createAPIToken makes a new API token, the token is only ever shown in this reply
+func createAPIToken(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.APIToken
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	var token string
+	var created auth.APIToken
+	var err = validateTokenScope(playerName, formData)
+	if err == nil {
+		session, _ := c.Cookie("token")
+		token, created, err = auth.CreateAPIToken(playerName, auth.APIToken{
+			Identity: auth.SessionIdentity(session),
+			Name:     formData.Name,
+			ReadOnly: formData.ReadOnly,
+			Servers:  formData.Servers,
+			Actions:  formData.Actions,
+		}, time.Duration(formData.Days)*24*time.Hour)
+	}
+
+	switch {
+	case err == nil:
+		success = http.StatusOK
+		storage.AuditWrite(playerName, "token:create", created.Name)
+	case err == auth.ErrAPITokenName, err == auth.ErrTooManyAPITokens, errors.Is(err, errTokenScope):
+		success = http.StatusBadRequest
+	default:
+		log.Printf("create api token error: %s", err.Error())
+		err = fmt.Errorf("Unable to create the api token")
+	}
+
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+		"tokens": auth.PlayerAPITokens(playerName),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	} else {
+		data["token"] = token
+		data["created"] = created
+	}
+	c.JSON(success, data)
+}
+
+// errTokenScope is returned for servers or actions that can't be in an API token's scope
+var errTokenScope = errors.New("invalid api token scope")
+
+// validateTokenScope checks that the servers are the player's to use and the actions exist
+func validateTokenScope(playerName string, formData forms.APIToken) error {
+	if formData.Days < 0 {
+		return errTokenScope
+	}
+	for _, id := range formData.Servers {
+		s, ok := server.Servers.Get(id)
+		if !ok || s.Deleted || !s.HasRole(playerName) && !s.IsOwner(playerName) && !auth.IsAdmin(playerName) {
+			return fmt.Errorf("%w: unknown server %s", errTokenScope, id)
+		}
+	}
+	for _, action := range formData.Actions {
+		if action != "create" && !server.IsAction(action) {
+			return fmt.Errorf("%w: unknown action %s", errTokenScope, action)
+		}
+	}
+	return nil
+}
+
+// revokeAPIToken deletes one of the player's API tokens
+func revokeAPIToken(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.Revoke
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	err := auth.RevokeAPIToken(playerName, formData.ID)
+	switch err {
+	case nil:
+		success = http.StatusOK
+		storage.AuditWrite(playerName, "token:revoke", formData.ID)
+	case auth.ErrNoSuchAPIToken:
+		success = http.StatusNotFound
+	default:
+		log.Printf("revoke api token error: %s", err.Error())
+		err = fmt.Errorf("Unable to revoke the api token")
+	}
+
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+		"tokens": auth.PlayerAPITokens(playerName),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
@@ -1,6 +1,8 @@
 package apiv1
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,8 +10,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jlmeeker/mcmanager/auth"
 	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/jobs"
+	"github.com/jlmeeker/mcmanager/metrics"
 	"github.com/jlmeeker/mcmanager/paper"
+	"github.com/jlmeeker/mcmanager/ports"
+	"github.com/jlmeeker/mcmanager/proxy"
 	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/sessions"
+	"github.com/jlmeeker/mcmanager/status"
 	"github.com/jlmeeker/mcmanager/storage"
 	"github.com/jlmeeker/mcmanager/vanilla"
 )
@@ -20,49 +28,131 @@ func AuditLogMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		serverID := c.Param("serverid")
 		action := c.Param("action")
-		playerName, _ := c.Cookie("player")
+		playerName := c.GetString("player")
+
+		var name string
+		if s, ok := server.Servers.Get(serverID); ok {
+			name = s.Name
+		}
 
-		storage.AuditWrite(playerName, action, fmt.Sprintf("%s (%s)", serverID, server.Servers[serverID].Name))
+		if auth.IsAdmin(playerName) {
+			action = "admin:" + action
+		}
+		storage.AuditWrite(auditWho(c), action, fmt.Sprintf("%s (%s)", serverID, name))
 		c.Next()
 	}
 }
 
 //AuthenticateMiddleware middleware
-//No need to error check the cookie checks, the verify will fail anyway
+//No need to error check the cookie checks, the verify will fail anyway.
+//Requests that change something must echo the session's CSRF token in the X-CSRF-Token header.
+//Scripts authenticate with an "Authorization: Bearer" API token instead (no CSRF, no cookies).
 func AuthenticateMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if bearer := bearerToken(c); bearer != "" {
+			authenticateAPIToken(c, bearer)
+			return
+		}
+
 		token, _ := c.Cookie("token")
 		playerName, _ := c.Cookie("player")
 
-		if auth.VerifyToken(playerName, token) {
-			c.Next()
+		if !auth.VerifySession(playerName, token) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"result": http.StatusUnauthorized,
+				"error":  "unauthorized",
+			})
 			return
 		}
-		c.AbortWithStatusJSON(http.StatusOK, gin.H{
-			"error": "unauthorized",
-		})
+
+		csrf := CSRFCookie(c, token)
+		if !safeMethod(c.Request.Method) && subtle.ConstantTimeCompare([]byte(c.GetHeader("X-CSRF-Token")), []byte(csrf)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"result": http.StatusForbidden,
+				"error":  "missing or invalid csrf token, reload the page",
+			})
+			return
+		}
+		c.Set("player", playerName)
+		c.Next()
+	}
+}
+
+// safeMethod returns if a request method only reads
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// CSRFCookie makes sure the browser has the CSRF token of its session, the web UI
+// reads it from the (not HttpOnly) csrf cookie and sends it back as a header
+func CSRFCookie(c *gin.Context, token string) string {
+	csrf := auth.SessionCSRF(token)
+	if cookie, _ := c.Cookie("csrf"); cookie != csrf && csrf != "" {
+		c.SetSameSite(http.SameSiteStrictMode)
+		c.SetCookie("csrf", csrf, int(auth.SessionLifetime.Seconds()), "/", "", secureCookies(c), false)
 	}
+	return csrf
 }
 
+// errConfirm is returned when a destructive action wasn't confirmed correctly
+var errConfirm = errors.New("confirmation does not match the server name")
+
 func V1Routes(v1 *gin.RouterGroup) {
 	// these routes available without authorization
-	v1.POST("/login", login)
+	v1.POST("/login/device", loginDevice)
+	v1.GET("/login/device/:id", loginDeviceStatus)
+	v1.POST("/login/local", loginPassword)
+	v1.GET("/login/oidc", loginRedirect)
+	v1.GET("/login/oidc/callback", loginCallback)
+	v1.GET("/login/providers", loginProviders)
+	v1.POST("/logout", logout)
 	v1.GET("/news", news)
 	v1.GET("/ping", ping)
 	v1.GET("/releases", releases)
+	v1.GET("/status", statusHandler)
 
 	// all routes below this line REQUIRE authentication
 	v1.Use(AuthenticateMiddleware())
-	v1.POST("/create", createHandler)
-	v1.POST("/logout", logout)
+	v1.POST("/create", tokenScope("create"), createHandler)
 	v1.GET("/servers", servers)
+	v1.GET("/events", eventStream)
+	v1.GET("/jobs", listJobs)
+	v1.GET("/trash", trash)
+	v1.GET("/jobs/:id", getJob)
 	v1.GET("/me", me)
+	v1.GET("/quota", quota)
+
+	// logins and tokens can only be managed from the web UI, not with an API token
+	rgl := v1.Group("")
+	rgl.Use(SessionOnly())
+	rgl.POST("/logout/all", logoutEverywhere)
+	rgl.GET("/sessions", listSessions)
+	rgl.POST("/sessions/revoke", revokeSession)
+	rgl.GET("/tokens", listAPITokens)
+	rgl.POST("/tokens", createAPIToken)
+	rgl.POST("/tokens/revoke", revokeAPIToken)
+
+	// manager-level admin routes
+	rga := v1.Group("/admin")
+	rga.Use(SessionOnly())
+	rga.Use(AdminMiddleware())
+	rga.GET("/servers", adminServers)
+	rga.GET("/admins", listAdmins)
+	rga.POST("/admins", editAdmins)
+	rga.GET("/accounts", listAccounts)
+	rga.POST("/accounts", editAccount)
+	rga.POST("/links", editLink)
 
 	// all routes below this line REQUIRE at least Op access to the requested server
 	rgs := v1.Group("/server")
 	rgs.Use(server.AuthorizeMiddleware())
 	rgs.Use(AuditLogMiddleware())
 	rgs.POST("/:serverid/:action", doAction)
+
+	// read-only server views, not audited
+	rgv := v1.Group("/server")
+	rgv.Use(server.AuthorizeMiddleware())
+	rgv.GET("/:serverid/:action", doView)
 }
 
 func doAction(c *gin.Context) {
@@ -75,6 +165,12 @@ func doAction(c *gin.Context) {
 		addWhitelist(c)
 	case "bkp":
 		backup(c)
+	case "cln":
+		clone(c)
+	case "coa":
+		addCoOwner(c)
+	case "cor":
+		removeCoOwner(c)
 	case "day":
 		day(c)
 	case "sav":
@@ -83,6 +179,8 @@ func doAction(c *gin.Context) {
 		clearWeather(c)
 	case "del":
 		delete(c)
+	case "edt":
+		edit(c)
 	case "rgn":
 		regen(c)
 	case "sta":
@@ -91,6 +189,34 @@ func doAction(c *gin.Context) {
 		stop(c)
 	case "upg":
 		upgrade(c)
+	case "und":
+		undelete(c)
+	case "own":
+		transferOwnership(c)
+	case "prg":
+		purge(c)
+	case "rdf":
+		defineRole(c)
+	case "rol":
+		grantRole(c)
+	default:
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+}
+
+func doView(c *gin.Context) {
+	var action = c.Param("action")
+
+	switch action {
+	case "backups":
+		backups(c)
+	case "metrics":
+		serverMetrics(c)
+	case "roles":
+		roles(c)
+	case "sessions":
+		playerSessions(c)
 	default:
 		c.AbortWithStatus(http.StatusNotFound)
 		return
@@ -103,12 +229,13 @@ func addOp(c *gin.Context) {
 	var formData forms.AddOp
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
 	if err := c.Bind(&formData); err != nil {
 		return
 	}
 
-	err := s.AddOpOnline(formData.OpName)
+	err := server.Servers.Do(serverID, func(s *server.Server) error {
+		return s.AddOpOnline(formData.OpName)
+	})
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -127,6 +254,20 @@ func addOp(c *gin.Context) {
 	c.JSON(success, data)
 }
 
+// addCoOwner gives another player owner permissions on a server
+func addCoOwner(c *gin.Context) {
+	var formData forms.CoOwner
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	ownershipResult(c, server.Servers.Do(c.Param("serverid"), func(s *server.Server) error {
+		return s.AddCoOwner(playerName, formData.PlayerName)
+	}))
+}
+
 // addWhitelist adds a player to a server's whitelist
 func addWhitelist(c *gin.Context) {
 	var success = http.StatusInternalServerError
@@ -137,8 +278,9 @@ func addWhitelist(c *gin.Context) {
 		return
 	}
 
-	s := server.Servers[serverID]
-	err := s.AddWhitelistOnline(formData.PlayerName)
+	err := server.Servers.Do(serverID, func(s *server.Server) error {
+		return s.AddWhitelistOnline(formData.PlayerName)
+	})
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -163,8 +305,9 @@ func backup(c *gin.Context) {
 	var err error
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err = s.Backup("initiated via web")
+	err = server.Servers.Do(serverID, func(s *server.Server) error {
+		return s.Backup("initiated via web")
+	})
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -181,14 +324,103 @@ func backup(c *gin.Context) {
 	c.JSON(success, data)
 }
 
+// backups lists the backup commits of a server (clone sources)
+func backups(c *gin.Context) {
+	var success = http.StatusInternalServerError
+
+	list, err := storage.Backups(c.Param("serverid"))
+	if err == nil {
+		success = http.StatusOK
+	} else {
+		log.Printf("backups error: %s", err.Error())
+		err = fmt.Errorf("Unable to list backups")
+	}
+
+	var data = gin.H{
+		"result":  success,
+		"error":   "",
+		"backups": list,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
+// clone copies a server into a new one owned by the requesting player
+func clone(c *gin.Context) {
+	var formData forms.Clone
+
+	playerName := c.GetString("player")
+	serverID := c.Param("serverid")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	var memory string
+	var size int64
+	if s, ok := server.Servers.Get(serverID); ok {
+		memory = s.MaxMem
+		size = storage.DirSize(s.ServerDir())
+	}
+	if formData.Name != "" {
+		if err := server.ValidateName(playerName, formData.Name, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"result": http.StatusBadRequest,
+				"error":  err.Error(),
+			})
+			return
+		}
+	}
+	if t, ok := c.Get("apitoken"); ok && !t.(auth.APIToken).Allows("", "create") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"result": http.StatusForbidden,
+			"error":  "not allowed for this api token",
+		})
+		return
+	}
+	release, err := server.ReserveCreate(playerName, memory, size)
+	if err != nil {
+		c.JSON(createStatus(err), gin.H{
+			"result": createStatus(err),
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	job := jobs.Run("clone", serverID, playerName, func(t *jobs.Task) error {
+		defer release()
+		var clone *server.Server
+		err := server.Servers.Do(serverID, func(s *server.Server) error {
+			var err error
+			s.SetReporter(t)
+			defer s.SetReporter(nil)
+			clone, err = s.Clone(playerName, formData)
+			return err
+		})
+		if err != nil {
+			log.Printf("clone error (%s): %s", serverID, err.Error())
+			t.Logf("clone failed: %s", err.Error())
+			return jobError("Failed to clone the server", err)
+		}
+		t.SetResult(clone.UUID)
+		return nil
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"job":    job,
+	})
+}
+
 // clearWeather sets the server weather to clear
 func clearWeather(c *gin.Context) {
 	var success = http.StatusInternalServerError
 	var err error
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err = s.WeatherClear()
+	err = server.Servers.Do(serverID, (*server.Server).WeatherClear)
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -208,36 +440,98 @@ func clearWeather(c *gin.Context) {
 
 // createHandler creates a new server instance
 func createHandler(c *gin.Context) {
-	var success = http.StatusInternalServerError
 	var formData forms.NewServer
 
-	playerName, _ := c.Cookie("player")
+	playerName := c.GetString("player")
 	if err := c.Bind(&formData); err != nil {
 		return
 	}
 
-	port := server.NextAvailablePort()
-	s, err := server.NewServer(playerName, formData, port)
-	if err == nil {
-		success = http.StatusOK
-		go server.LoadServers()
-	} else {
-		log.Printf("create error (%s): %s, attempting to clean up\n", s.Name, err.Error())
-		err = fmt.Errorf("Failed to create the server")
-		s.Delete() // ignore any error here
+	if err := server.ValidateName(playerName, formData.Name, ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"result": http.StatusBadRequest,
+			"page":   formData.Page,
+			"error":  err.Error(),
+		})
+		return
 	}
 
-	var data = gin.H{
-		"result": success,
+	release, err := server.ReserveCreate(playerName, formData.Memory, 0)
+	if err != nil {
+		c.JSON(createStatus(err), gin.H{
+			"result": createStatus(err),
+			"page":   formData.Page,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	job := jobs.Run("create", "", playerName, func(t *jobs.Task) error {
+		defer release()
+		s, err := server.NewServer(playerName, formData, formData.Port, t)
+		var admission server.AdmissionError
+		if errors.As(err, &admission) {
+			// the server was created, only starting it now was refused
+			t.Logf("created %s but did not start it: %s", s.UUID, err.Error())
+			t.SetResult(s.UUID)
+			return err
+		}
+		if err != nil {
+			log.Printf("create error (%s): %s, attempting to clean up\n", s.Name, err.Error())
+			// ignore any error here, the server is only registered if starting it failed
+			if server.Servers.Do(s.UUID, (*server.Server).Delete) == server.ErrNotFound {
+				s.Delete()
+			}
+			t.Logf("create failed: %s", err.Error())
+			return jobError("Failed to create the server", err)
+		}
+		t.SetResult(s.UUID)
+		return nil
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
 		"page":   formData.Page,
 		"error":  "",
+		"job":    job,
+	})
+}
+
+// jobError is the error a failed create or clone job shows its player, problems with
+// the request or the host are passed on while anything else only gets the generic
+// message (the detail is in the log and the job log)
+func jobError(generic string, err error) error {
+	var admission server.AdmissionError
+	switch {
+	case errors.As(err, &admission):
+		return err
+	case errors.Is(err, server.ErrNameEmpty), errors.Is(err, server.ErrNameTaken), errors.Is(err, server.ErrNameTooLong),
+		errors.Is(err, server.ErrNameInvalid), errors.Is(err, ports.ErrUnavailable):
+		return err
 	}
+	return errors.New(generic)
+}
 
-	if err != nil {
-		data["error"] = err.Error()
+// createStatus is the http status for a create policy error
+func createStatus(err error) int {
+	switch err.(type) {
+	case server.QuotaError:
+		return http.StatusForbidden
 	}
+	if err == server.ErrNotCreator {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
 
-	c.JSON(success, data)
+// quota returns whether the player may create servers, and their usage and limits
+func quota(c *gin.Context) {
+	playerName := c.GetString("player")
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"quota":  server.PlayerQuota(playerName),
+	})
 }
 
 // day sets the server time to day
@@ -246,9 +540,7 @@ func day(c *gin.Context) {
 	var err error
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-
-	err = s.Day()
+	err = server.Servers.Do(serverID, (*server.Server).Day)
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -272,11 +564,9 @@ func delete(c *gin.Context) {
 	var err error
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err = s.Delete()
+	err = server.Servers.Do(serverID, (*server.Server).Delete)
 	if err == nil {
 		success = http.StatusOK
-		go server.LoadServers()
 	} else {
 		log.Printf("delete error: %s", err.Error())
 		err = fmt.Errorf("Unable to delete")
@@ -292,72 +582,253 @@ func delete(c *gin.Context) {
 	c.JSON(success, data)
 }
 
-// login processes a user login request
-func login(c *gin.Context) {
+// edit changes the name, description, tags and notes of a server
+func edit(c *gin.Context) {
 	var success = http.StatusInternalServerError
-	var formData forms.Login
+	var formData forms.Edit
 
+	playerName := c.GetString("player")
+	serverID := c.Param("serverid")
 	if err := c.Bind(&formData); err != nil {
-		log.Printf("bin error: %s", err.Error())
 		return
 	}
 
+	err := server.Servers.Do(serverID, func(s *server.Server) error {
+		return s.Edit(playerName, formData)
+	})
+	switch err {
+	case nil:
+		success = http.StatusOK
+	case server.ErrNameTaken:
+		success = http.StatusConflict
+	case server.ErrNameEmpty, server.ErrNameTooLong, server.ErrNameInvalid, server.ErrMetaTooLong:
+		success = http.StatusBadRequest
+	default:
+		log.Printf("edit error: %s", err.Error())
+		err = fmt.Errorf("Unable to save changes")
+	}
+
 	var data = gin.H{
 		"result": success,
 		"error":  "",
 	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
+// loginDevice starts a Microsoft account device-code login
+func loginDevice(c *gin.Context) {
+	var success = http.StatusInternalServerError
 
-	token, playerName, err := auth.Authenticate(formData.Username, formData.Password)
+	var dl auth.DeviceLogin
+	err := loginThrottle(c, "")
+	if err == nil {
+		dl, err = auth.StartDeviceLogin()
+	}
 	if err == nil {
-		var secure bool
-		if c.Request.Proto == "https" {
-			secure = true
-		}
-		c.SetSameSite(http.SameSiteStrictMode)
-		c.SetCookie("token", token, 604800, "/", "", secure, true) // 604800 = 1 week
-		c.SetCookie("player", playerName, 604800, "/", "", secure, true)
-		data["success"] = http.StatusOK
-		data["playername"] = playerName
-		data["token"] = token
-		data["page"] = formData.Page
 		success = http.StatusOK
+	} else if _, ok := err.(auth.ThrottleError); ok {
+		success = http.StatusTooManyRequests
+	} else if err == auth.ErrNoClientID {
+		success = http.StatusServiceUnavailable
 	} else {
-		log.Printf("login error: %s", err.Error())
-		err = fmt.Errorf("login failed")
+		log.Printf("device login error: %s", err.Error())
+		err = fmt.Errorf("Unable to start the microsoft login")
 	}
 
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+		"login":  dl,
+	}
 	if err != nil {
 		data["error"] = err.Error()
 	}
 	c.JSON(success, data)
 }
 
-// logout processes a user logout request
+// loginDeviceStatus reports on a device-code login, logging the player in once they're done
+func loginDeviceStatus(c *gin.Context) {
+	dl, ok := auth.DeviceLoginStatus(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"result": http.StatusNotFound,
+			"error":  "login not found or expired",
+		})
+		return
+	}
+
+	var data = gin.H{
+		"result": http.StatusOK,
+		"error":  dl.Error,
+		"state":  dl.State,
+		"page":   c.Query("page"),
+	}
+
+	switch dl.State {
+	case auth.DeviceDone:
+		err := finishLogin(c, auth.Identity{
+			Provider:   "microsoft",
+			Subject:    dl.PlayerUUID,
+			PlayerName: dl.PlayerName,
+			PlayerUUID: dl.PlayerUUID,
+		})
+		if err != nil {
+			log.Printf("device login error: %s", err.Error())
+			data["state"] = auth.DeviceFailed
+			data["error"] = "Unable to log in"
+			break
+		}
+		data["playername"] = dl.PlayerName
+	case auth.DeviceFailed:
+		loginFailed(c, "microsoft", "", dl.Error)
+		log.Printf("device login failed: %s", dl.Error)
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// setLoginCookies sets the session cookies of a logged in player
+func setLoginCookies(c *gin.Context, token, playerName string) {
+	var maxAge = int(auth.SessionLifetime.Seconds())
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie("token", token, maxAge, "/", "", secureCookies(c), true)
+	c.SetCookie("player", playerName, maxAge, "/", "", secureCookies(c), true)
+	CSRFCookie(c, token)
+}
+
+// clearLoginCookies removes the session cookies
+func clearLoginCookies(c *gin.Context) {
+	c.SetCookie("token", "", -1, "/", "", secureCookies(c), true)
+	c.SetCookie("player", "", -1, "/", "", secureCookies(c), true)
+	c.SetCookie("csrf", "", -1, "/", "", secureCookies(c), false)
+}
+
+// secureCookies returns if cookies should be limited to https, the browser
+// talks https to us directly or to a trusted proxy in front of us
+func secureCookies(c *gin.Context) bool {
+	return proxy.IsHTTPS(c.Request)
+}
+
+// logout processes a user logout request, ending the session
 func logout(c *gin.Context) {
 	var success = http.StatusOK
-	c.SetCookie("token", "", 0, "/", "", true, true)
-	c.SetCookie("player", "", 0, "/", "", true, true)
+	token, _ := c.Cookie("token")
+
+	// it isn't behind AuthenticateMiddleware so stale cookies can be cleared, a live
+	// session still needs its CSRF token so other sites can't log the player out
+	if csrf := auth.SessionCSRF(token); csrf != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("X-CSRF-Token")), []byte(csrf)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"result": http.StatusForbidden,
+			"error":  "missing or invalid csrf token, reload the page",
+		})
+		return
+	}
+
+	auth.EndSession(token)
+	clearLoginCookies(c)
+
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+	}
+
+	c.JSON(success, data)
+}
+
+// logoutEverywhere ends every session of the player, this one included
+func logoutEverywhere(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	playerName := c.GetString("player")
+
+	err := auth.RevokePlayerSessions(playerName)
+	if err == nil {
+		success = http.StatusOK
+		storage.AuditWrite(playerName, "logout:all", "")
+		clearLoginCookies(c)
+	} else {
+		log.Printf("logout everywhere error: %s", err.Error())
+		err = fmt.Errorf("Unable to log out everywhere")
+	}
 
 	var data = gin.H{
 		"result": success,
 		"error":  "",
 	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
+// listSessions lists the player's sessions
+func listSessions(c *gin.Context) {
+	token, _ := c.Cookie("token")
+	playerName := c.GetString("player")
+	c.JSON(http.StatusOK, gin.H{
+		"result":   http.StatusOK,
+		"error":    "",
+		"sessions": auth.PlayerSessions(playerName, token),
+	})
+}
+
+// revokeSession ends one of the player's sessions
+func revokeSession(c *gin.Context) {
+	var success = http.StatusInternalServerError
+	var formData forms.Revoke
+
+	token, _ := c.Cookie("token")
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	err := auth.RevokeSession(playerName, formData.ID)
+	switch err {
+	case nil:
+		success = http.StatusOK
+	case auth.ErrNoSuchSession:
+		success = http.StatusNotFound
+	default:
+		log.Printf("revoke session error: %s", err.Error())
+		err = fmt.Errorf("Unable to revoke the session")
+	}
 
+	var data = gin.H{
+		"result":   success,
+		"error":    "",
+		"sessions": auth.PlayerSessions(playerName, token),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
 	c.JSON(success, data)
 }
 
 // me get my preferences
 func me(c *gin.Context) {
-	playerName, _ := c.Cookie("player")
+	playerName := c.GetString("player")
 	c.JSON(http.StatusOK, gin.H{
 		"hostname":   server.HOSTNAME,
 		"result":     http.StatusOK,
 		"error":      "",
+		"isAdmin":    auth.IsAdmin(playerName),
 		"isLoggedIn": true,
 		"playerName": playerName,
 	})
 }
 
+// serverMetrics returns the collected resource and tick time series for a server
+func serverMetrics(c *gin.Context) {
+	serverID := c.Param("serverid")
+	c.JSON(http.StatusOK, gin.H{
+		"result":  http.StatusOK,
+		"error":   "",
+		"metrics": metrics.Series(serverID),
+	})
+}
+
 // news returns the current news items
 func news(c *gin.Context) {
 	var data = gin.H{
@@ -367,26 +838,29 @@ func news(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 }
 
-// ping is a simple liveness check
-func ping(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"message": "pong",
-		"error":   "",
-	})
-}
-
-// regen runs a backup of a server
-func regen(c *gin.Context) {
+// purge permanently removes a deleted server, the owner must confirm by sending the server name
+func purge(c *gin.Context) {
 	var success = http.StatusInternalServerError
+	var formData forms.Purge
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err := s.Regen()
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	err := server.Servers.Do(serverID, func(s *server.Server) error {
+		if formData.Confirm != s.Name {
+			return errConfirm
+		}
+		return s.Purge(formData.Archive || server.TrashArchive)
+	})
 	if err == nil {
 		success = http.StatusOK
+	} else if err == errConfirm {
+		success = http.StatusBadRequest
 	} else {
-		log.Printf("Regen failed: %s", err.Error())
-		err = fmt.Errorf("Regen failed")
+		log.Printf("purge error: %s", err.Error())
+		err = fmt.Errorf("Unable to purge")
 	}
 
 	var data = gin.H{
@@ -399,11 +873,33 @@ func regen(c *gin.Context) {
 	c.JSON(success, data)
 }
 
+// ping is a simple liveness check
+func ping(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"message": "pong",
+		"error":   "",
+	})
+}
+
+// regen generates a new world for a server (as a background job)
+func regen(c *gin.Context) {
+	playerName := c.GetString("player")
+	serverID := c.Param("serverid")
+
+	job := serverJob("regen", serverID, playerName, (*server.Server).Regen)
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"job":    job,
+	})
+}
+
 // releases returns a list of current, vanilla releases
 func releases(c *gin.Context) {
 	var success = http.StatusInternalServerError
 
 	err := vanilla.RefreshReleases()
+	status.RecordRefresh("vanilla releases", err)
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -424,13 +920,26 @@ func releases(c *gin.Context) {
 	c.JSON(success, data)
 }
 
+// removeCoOwner takes owner permissions on a server away from a co-owner
+func removeCoOwner(c *gin.Context) {
+	var formData forms.CoOwner
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	ownershipResult(c, server.Servers.Do(c.Param("serverid"), func(s *server.Server) error {
+		return s.RemoveCoOwner(playerName, formData.PlayerName)
+	}))
+}
+
 // save tells a server to save data to disk
 func save(c *gin.Context) {
 	var success = http.StatusInternalServerError
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err := s.Save()
+	err := server.Servers.Do(serverID, (*server.Server).Save)
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -454,20 +963,53 @@ func servers(c *gin.Context) {
 		"servers": make(map[string]server.WebView),
 	}
 
-	playerName, _ := c.Cookie("player")
-	result["servers"] = server.ServersWebView(playerName)
+	playerName := c.GetString("player")
+	var views = make(map[string]server.WebView)
+	for id, s := range server.ServersWithPlayer(playerName) {
+		if tokenAllowsServer(c, id) {
+			views[id] = s.WebView(playerName)
+		}
+	}
+	result["servers"] = views
 	c.JSON(http.StatusOK, result)
 }
 
+// playerSessions returns per-player playtime, last seen and peak concurrency for a server
+func playerSessions(c *gin.Context) {
+	var success = http.StatusInternalServerError
+
+	serverID := c.Param("serverid")
+	stats, history, err := sessions.Stats(serverID)
+	if err == nil {
+		success = http.StatusOK
+	} else {
+		log.Printf("sessions error: %s", err.Error())
+		err = fmt.Errorf("Unable to load sessions")
+	}
+
+	var data = gin.H{
+		"result":  success,
+		"error":   "",
+		"players": stats,
+		"peak":    history.Peak,
+		"peakat":  history.PeakAt,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
+
 // start starts a server instance
 func start(c *gin.Context) {
 	var success = http.StatusInternalServerError
 
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err := s.Start()
+	err := server.Servers.Do(serverID, (*server.Server).Start)
 	if err == nil {
 		success = http.StatusOK
+	} else if _, ok := err.(server.AdmissionError); ok {
+		success = http.StatusServiceUnavailable
 	} else {
 		log.Printf("start error: %s", err.Error())
 		err = fmt.Errorf("failed to start")
@@ -483,13 +1025,29 @@ func start(c *gin.Context) {
 	c.JSON(success, data)
 }
 
+// statusHandler returns manager and host health, plus the health of servers visible to the player
+func statusHandler(c *gin.Context) {
+	token, _ := c.Cookie("token")
+	playerName, _ := c.Cookie("player")
+	if !auth.VerifySession(playerName, token) {
+		playerName = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"status": status.Get(playerName),
+	})
+}
+
 // stop stops a running instance
 func stop(c *gin.Context) {
 	var success = http.StatusInternalServerError
 	var err error
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
-	err = s.Stop(2)
+	err = server.Servers.Do(serverID, func(s *server.Server) error {
+		return s.Stop(2)
+	})
 	if err == nil {
 		success = http.StatusOK
 	} else {
@@ -507,21 +1065,84 @@ func stop(c *gin.Context) {
 	c.JSON(success, data)
 }
 
-// delete stops and removes a server... permanently
-func upgrade(c *gin.Context) {
+// transferOwnership hands a server over to another player
+func transferOwnership(c *gin.Context) {
+	var formData forms.Transfer
+
+	playerName := c.GetString("player")
+	if err := c.Bind(&formData); err != nil {
+		return
+	}
+
+	ownershipResult(c, server.Servers.Do(c.Param("serverid"), func(s *server.Server) error {
+		return s.TransferOwnership(playerName, formData.PlayerName, formData.Keep)
+	}))
+}
+
+// ownershipResult replies to the ownership actions, telling the player what was wrong with the request
+func ownershipResult(c *gin.Context, err error) {
 	var success = http.StatusInternalServerError
-	var err error
+	switch err {
+	case nil:
+		success = http.StatusOK
+	case server.ErrUnknownPlayer, server.ErrAlreadyOwner, server.ErrNotCoOwner, server.ErrOwnerNameTaken:
+		success = http.StatusBadRequest
+	default:
+		log.Printf("ownership error: %s", err.Error())
+		err = fmt.Errorf("Unable to change ownership")
+	}
+
+	var data = gin.H{
+		"result": success,
+		"error":  "",
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	c.JSON(success, data)
+}
 
+// trash returns the player's deleted servers
+func trash(c *gin.Context) {
+	playerName := c.GetString("player")
+	var items = []server.TrashView{}
+	for _, item := range server.Trash(playerName) {
+		if tokenAllowsServer(c, item.UUID) {
+			items = append(items, item)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"trash":  items,
+	})
+}
+
+// undelete restores a server from the trash, it counts against the owner's quota again
+// (its disk use already does) unless an admin restores it
+func undelete(c *gin.Context) {
+	var success = http.StatusInternalServerError
+
+	playerName := c.GetString("player")
 	serverID := c.Param("serverid")
-	s := server.Servers[serverID]
+	if s, ok := server.Servers.Get(serverID); ok && s.Deleted && !auth.IsAdmin(playerName) {
+		release, err := server.ReserveCreate(s.Owner, s.MaxMem, 0)
+		if err != nil {
+			c.JSON(createStatus(err), gin.H{
+				"result": createStatus(err),
+				"error":  err.Error(),
+			})
+			return
+		}
+		defer release()
+	}
 
-	err = s.Upgrade()
+	err := server.Servers.Do(serverID, (*server.Server).Undelete)
 	if err == nil {
 		success = http.StatusOK
-		go server.LoadServers()
 	} else {
-		log.Printf("upgrade error: %s", err.Error())
-		err = fmt.Errorf("unable to upgrade %s: %s", s.Name, err.Error())
+		log.Printf("undelete error: %s", err.Error())
+		err = fmt.Errorf("Unable to restore")
 	}
 
 	var data = gin.H{
@@ -533,3 +1154,16 @@ func upgrade(c *gin.Context) {
 	}
 	c.JSON(success, data)
 }
+
+// upgrade moves a server to the latest release (as a background job)
+func upgrade(c *gin.Context) {
+	playerName := c.GetString("player")
+	serverID := c.Param("serverid")
+
+	job := serverJob("upgrade", serverID, playerName, (*server.Server).Upgrade)
+	c.JSON(http.StatusOK, gin.H{
+		"result": http.StatusOK,
+		"error":  "",
+		"job":    job,
+	})
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Admin is a manager-level superuser who can see and control every server.
+// Admins are matched by Mojang UUID when known, Name is the last known name.
+type Admin struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// Admin list errors
+var (
+	ErrAlreadyAdmin = errors.New("player is already an admin")
+	ErrNotAdmin     = errors.New("player is not an admin")
+	ErrLastAdmin    = errors.New("cannot remove the last admin")
+)
+
+var (
+	admins   = []Admin{}
+	adminsMu sync.RWMutex
+)
+
+// LoadAdmins reads the admin list from disk. The (comma separated) names from the flag
+// only seed it when there is no list yet (or it is empty), afterwards the Admin page
+// manages it so removed admins stay removed across restarts.
+func LoadAdmins(flagValue string) error {
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+
+	fb, err := os.ReadFile(filepath.Join(storage.STORAGEDIR, "admins.json"))
+	if err == nil {
+		err = json.Unmarshal(fb, &admins)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(admins) > 0 {
+		return nil
+	}
+
+	var changed bool
+	for _, name := range strings.Split(flagValue, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !isAdmin(name) {
+			admins = append(admins, Admin{UUID: KnownUUID(name), Name: name})
+			changed = true
+		}
+	}
+	if changed {
+		return saveAdmins()
+	}
+	return nil
+}
+
+// saveAdmins writes the admin list to disk, callers must hold adminsMu
+func saveAdmins() error {
+	jb, err := json.MarshalIndent(admins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(storage.STORAGEDIR, "admins.json"), jb, 0600)
+}
+
+// IsAdmin returns if a player is a manager-level admin
+func IsAdmin(player string) bool {
+	adminsMu.RLock()
+	defer adminsMu.RUnlock()
+	return isAdmin(player)
+}
+
+func isAdmin(player string) bool {
+	for _, a := range admins {
+		if SamePlayer(player, a.Name, a.UUID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admins returns the admins with their current names
+func Admins() []Admin {
+	adminsMu.RLock()
+	defer adminsMu.RUnlock()
+
+	var list = []Admin{}
+	for _, a := range admins {
+		if name := KnownName(a.UUID); name != "" {
+			a.Name = name
+		}
+		list = append(list, a)
+	}
+	return list
+}
+
+// AddAdmin makes a player a manager-level admin
+func AddAdmin(player string) error {
+	player = strings.TrimSpace(player)
+	uuid, err := PlayerUUID(player)
+	if err != nil {
+		return err
+	}
+
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+	if isAdmin(player) {
+		return ErrAlreadyAdmin
+	}
+	admins = append(admins, Admin{UUID: uuid, Name: player})
+	return saveAdmins()
+}
+
+// RemoveAdmin takes manager-level admin away from a player
+func RemoveAdmin(player string) error {
+	player = strings.TrimSpace(player)
+
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+
+	var kept = []Admin{}
+	for _, a := range admins {
+		if !SamePlayer(player, a.Name, a.UUID) {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == len(admins) {
+		return ErrNotAdmin
+	}
+	if len(kept) == 0 {
+		return ErrLastAdmin
+	}
+	admins = kept
+	return saveAdmins()
+}
+
+// AdoptAdminUUID records the UUID of an admin that was configured by name only
+func AdoptAdminUUID(player string) {
+	uuid := KnownUUID(player)
+	if uuid == "" {
+		return
+	}
+
+	adminsMu.Lock()
+	defer adminsMu.Unlock()
+
+	var changed bool
+	for i, a := range admins {
+		if a.UUID == "" && a.Name == player {
+			admins[i].UUID = uuid
+			changed = true
+		}
+	}
+	if changed {
+		saveAdmins()
+	}
+}
+
+// SamePlayer checks a player name against a recorded name and UUID, the UUID wins
+// when both are known so a name that changed hands doesn't match
+func SamePlayer(player, name, uuid string) bool {
+	if player == "" {
+		return false
+	}
+	if uuid != "" {
+		if known := KnownUUID(player); known != "" {
+			return known == uuid
+		}
+	}
+	return player == name
+}
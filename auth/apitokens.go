@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// APITokenPrefix starts every API token so they are easy to spot (and to scan for in leaks)
+const APITokenPrefix = "mcm_"
+
+// MaxAPITokens is how many API tokens a player may have
+const MaxAPITokens = 25
+
+// API token errors
+var (
+	ErrNoSuchAPIToken   = errors.New("no such api token")
+	ErrAPITokenName     = errors.New("api tokens need a name (up to 64 characters)")
+	ErrTooManyAPITokens = errors.New("too many api tokens, revoke some first")
+)
+
+// APIToken is a personal token for scripts, it acts as its player limited to its scope.
+// Only a hash of the token is kept, the token itself is shown once when it's created.
+// Identity is the login the token was made with, changing that login revokes it.
+type APIToken struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Player   string     `json:"player"`
+	Identity string     `json:"identity"`
+	Hash     string     `json:"hash,omitempty"`
+	ReadOnly bool       `json:"readonly"`
+	Servers  []string   `json:"servers"`
+	Actions  []string   `json:"actions"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastused,omitempty"`
+	LastIP   string     `json:"lastip"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// Allows checks a server action against the token's scope (the player's own
+// permissions are checked separately). An empty server ID is a request that
+// isn't about one server, like creating one.
+func (t APIToken) Allows(serverID, action string) bool {
+	if !t.AllowsServer(serverID) {
+		return false
+	}
+	if len(t.Actions) > 0 && !contains(t.Actions, action) {
+		return false
+	}
+	return true
+}
+
+// AllowsServer reports whether the server is in the token's scope at all
+func (t APIToken) AllowsServer(serverID string) bool {
+	return len(t.Servers) == 0 || contains(t.Servers, serverID)
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokens maps token hashes to tokens
+var (
+	apiTokens   = make(map[string]*APIToken)
+	apiTokensMu sync.Mutex
+)
+
+// LoadAPITokens reads the API tokens from disk
+func LoadAPITokens() error {
+	fb, err := os.ReadFile(filepath.Join(storage.STORAGEDIR, "apitokens.json"))
+	if err != nil {
+		return err
+	}
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+	return json.Unmarshal(fb, &apiTokens)
+}
+
+// saveAPITokens writes the API tokens to disk, callers must hold apiTokensMu
+func saveAPITokens() error {
+	jb, err := json.MarshalIndent(apiTokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(storage.STORAGEDIR, "apitokens.json"), jb, 0600)
+}
+
+// CreateAPIToken makes a new API token for a player, the returned token string
+// can't be looked up again later
+func CreateAPIToken(player string, scope APIToken, lifetime time.Duration) (string, APIToken, error) {
+	scope.Name = strings.TrimSpace(scope.Name)
+	if scope.Name == "" || len(scope.Name) > 64 {
+		return "", APIToken{}, ErrAPITokenName
+	}
+
+	var b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", APIToken{}, err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	id, err := randomID()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+
+	var t = &APIToken{
+		ID:       id,
+		Name:     scope.Name,
+		Player:   player,
+		Identity: scope.Identity,
+		Hash:     hashToken(token),
+		ReadOnly: scope.ReadOnly,
+		Servers:  scope.Servers,
+		Actions:  scope.Actions,
+		Created:  time.Now(),
+	}
+	if lifetime > 0 {
+		expires := t.Created.Add(lifetime)
+		t.Expires = &expires
+	}
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	var count int
+	for _, other := range apiTokens {
+		if other.Player == player {
+			count++
+		}
+	}
+	if count >= MaxAPITokens {
+		return "", APIToken{}, ErrTooManyAPITokens
+	}
+
+	apiTokens[t.Hash] = t
+	if err = saveAPITokens(); err != nil {
+		return "", APIToken{}, err
+	}
+
+	var view = *t
+	view.Hash = ""
+	return token, view, nil
+}
+
+// VerifyAPIToken looks up the API token of a request and records its use
+func VerifyAPIToken(token, ip string) (APIToken, bool) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return APIToken{}, false
+	}
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	t, ok := apiTokens[hashToken(token)]
+	if !ok {
+		return APIToken{}, false
+	}
+
+	var now = time.Now()
+	if t.Expires != nil && now.After(*t.Expires) {
+		return APIToken{}, false
+	}
+	if t.LastUsed == nil || now.Sub(*t.LastUsed) > time.Minute || t.LastIP != ip {
+		t.LastUsed = &now
+		t.LastIP = ip
+		saveAPITokens()
+	}
+	return *t, true
+}
+
+// PlayerAPITokens lists a player's API tokens, newest first
+func PlayerAPITokens(player string) []APIToken {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	var list = []APIToken{}
+	for _, t := range apiTokens {
+		if t.Player == player {
+			var view = *t
+			view.Hash = ""
+			list = append(list, view)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+// RevokeAPIToken deletes one of a player's API tokens
+func RevokeAPIToken(player, id string) error {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	for hash, t := range apiTokens {
+		if t.ID == id && t.Player == player {
+			delete(apiTokens, hash)
+			return saveAPITokens()
+		}
+	}
+	return ErrNoSuchAPIToken
+}
+
+// revokeIdentityAPITokens deletes every API token made while logged in with an identity
+func revokeIdentityAPITokens(identity string) error {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	var changed bool
+	for hash, t := range apiTokens {
+		if t.Identity == identity {
+			delete(apiTokens, hash)
+			changed = true
+		}
+	}
+	if changed {
+		return saveAPITokens()
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idTokenLeeway is how much clock difference with the issuer is tolerated
+const idTokenLeeway = time.Minute
+
+// ErrIDToken is returned (wrapped) when an id token doesn't check out
+var ErrIDToken = errors.New("invalid id token")
+
+// jwk is a public key from the issuer's key set, only the fields for RSA and EC keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var (
+	jwksKeys    map[string]crypto.PublicKey
+	jwksFetched time.Time
+	jwksMu      sync.Mutex
+)
+
+// signingKey returns the issuer's key with the given id. The key set is fetched
+// again (at most once a minute) when the key isn't known so rotated keys are picked up.
+func signingKey(jwksURI, kid string) (crypto.PublicKey, error) {
+	jwksMu.Lock()
+	defer jwksMu.Unlock()
+
+	key := findKey(jwksKeys, kid)
+	if key == nil && time.Since(jwksFetched) > time.Minute {
+		keys, err := fetchJWKS(jwksURI)
+		if err != nil {
+			return nil, err
+		}
+		jwksKeys = keys
+		jwksFetched = time.Now()
+		key = findKey(jwksKeys, kid)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrIDToken, kid)
+	}
+	return key, nil
+}
+
+// findKey looks up a key by id, a token without a key id can only use a key set of one
+func findKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[kid]
+}
+
+// fetchJWKS reads the issuer's signing keys, keys of other types or uses are skipped
+func fetchJWKS(jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(jwksURI, "", &set); err != nil {
+		return nil, err
+	}
+
+	var keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if k.Crv != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// verifyIDToken checks the signature (RS256 or ES256), issuer, audience, expiry
+// and nonce of an id token and returns its claims
+func verifyIDToken(raw, jwksURI, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrIDToken)
+	}
+
+	key, err := signingKey(jwksURI, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) != nil {
+			return nil, fmt.Errorf("%w: bad %s signature", ErrIDToken, header.Alg)
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(pub, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, fmt.Errorf("%w: bad %s signature", ErrIDToken, header.Alg)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key", ErrIDToken)
+	}
+
+	var claims map[string]interface{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	iss, _ := claims["iss"].(string)
+	exp, _ := claims["exp"].(float64)
+	azp, hasAzp := claims["azp"].(string)
+	sub, _ := claims["sub"].(string)
+	got, _ := claims["nonce"].(string)
+	switch {
+	case strings.TrimSuffix(iss, "/") != OIDC.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrIDToken, iss)
+	case !hasAudience(claims["aud"], OIDC.ClientID) || (hasAzp && azp != OIDC.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrIDToken)
+	case time.Now().After(time.Unix(int64(exp), 0).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: expired", ErrIDToken)
+	case nonce == "" || got != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrIDToken)
+	case sub == "":
+		return nil, fmt.Errorf("%w: no subject", ErrIDToken)
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url JSON part of a token
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrIDToken)
+	}
+	return nil
+}
+
+// hasAudience reports whether an aud claim (a string or a list) names the client
+func hasAudience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if v == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
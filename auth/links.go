@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Link ties a local or OIDC identity to the Minecraft player it acts as
+type Link struct {
+	Identity   string `json:"identity"`
+	PlayerName string `json:"playername"`
+	PlayerUUID string `json:"playeruuid"`
+}
+
+// Identity link errors
+var (
+	ErrBadIdentity       = errors.New("identity must look like provider:subject")
+	ErrNotLinkedIdentity = errors.New("identity is not linked")
+)
+
+// NotLinkedError is returned when an identity logged in but isn't linked to a player yet
+type NotLinkedError struct {
+	Identity string
+}
+
+func (e NotLinkedError) Error() string {
+	return fmt.Sprintf("%s is not linked to a Minecraft player, ask an admin to link it", e.Identity)
+}
+
+// links maps "provider:subject" to the linked player
+var (
+	links   = make(map[string]Link)
+	linksMu sync.RWMutex
+)
+
+// identityKey is how an identity is written in the links file and on the admin page
+func identityKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+// LoadLinks reads the identity links from disk
+func LoadLinks() error {
+	fb, err := os.ReadFile(filepath.Join(storage.STORAGEDIR, "links.json"))
+	if err != nil {
+		return err
+	}
+
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	return json.Unmarshal(fb, &links)
+}
+
+// saveLinks writes the identity links to disk, callers must hold linksMu
+func saveLinks() error {
+	jb, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(storage.STORAGEDIR, "links.json"), jb, 0600)
+}
+
+// LinkIdentity links an identity ("provider:subject") to a Minecraft player
+func LinkIdentity(identity, player string) error {
+	identity = strings.TrimSpace(identity)
+	player = strings.TrimSpace(player)
+	if !strings.Contains(identity, ":") {
+		return ErrBadIdentity
+	}
+
+	uuid, err := PlayerUUID(player)
+	if err != nil {
+		return err
+	}
+
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	old, relinked := links[identity]
+	links[identity] = Link{Identity: identity, PlayerName: player, PlayerUUID: uuid}
+	if err = saveLinks(); err != nil {
+		return err
+	}
+
+	// sessions and api tokens made as the previous player must not keep its permissions
+	if relinked && old.PlayerUUID != uuid {
+		return RevokeIdentitySessions(identity)
+	}
+	return nil
+}
+
+// UnlinkIdentity removes the link of an identity
+func UnlinkIdentity(identity string) error {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+
+	if _, ok := links[identity]; !ok {
+		return ErrNotLinkedIdentity
+	}
+	delete(links, identity)
+	if err := saveLinks(); err != nil {
+		return err
+	}
+	return RevokeIdentitySessions(identity)
+}
+
+// Links returns the identity links with the players' current names
+func Links() []Link {
+	linksMu.RLock()
+	defer linksMu.RUnlock()
+
+	var list = []Link{}
+	for _, l := range links {
+		if name := KnownName(l.PlayerUUID); name != "" {
+			l.PlayerName = name
+		}
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Identity < list[j].Identity })
+	return list
+}
+
+// linkedIdentity fills in the player an identity is linked to
+func linkedIdentity(provider, subject string) (Identity, error) {
+	var key = identityKey(provider, subject)
+
+	linksMu.RLock()
+	l, ok := links[key]
+	linksMu.RUnlock()
+	if !ok {
+		return Identity{}, NotLinkedError{Identity: key}
+	}
+
+	var id = Identity{Provider: provider, Subject: subject, PlayerName: l.PlayerName, PlayerUUID: l.PlayerUUID}
+	if name := KnownName(l.PlayerUUID); name != "" {
+		id.PlayerName = name
+	}
+	return id, nil
+}
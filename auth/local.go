@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password a local account may have
+const MinPasswordLength = 10
+
+// Local account errors
+var (
+	ErrBadLogin      = errors.New("wrong username or password")
+	ErrBadUsername   = errors.New("usernames are 1-32 letters, digits, dots, dashes or underscores")
+	ErrShortPassword = errors.New("password is too short")
+	ErrNoSuchAccount = errors.New("no such account")
+)
+
+var usernameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// Account is a local username/password login, the password is only kept as a bcrypt hash
+type Account struct {
+	Username string    `json:"username"`
+	Hash     string    `json:"hash"`
+	Created  time.Time `json:"created"`
+	Changed  time.Time `json:"changed"`
+}
+
+// AccountView is an account as shown to admins
+type AccountView struct {
+	Username   string    `json:"username"`
+	PlayerName string    `json:"playername"`
+	Changed    time.Time `json:"changed"`
+}
+
+var (
+	accounts   = make(map[string]Account)
+	accountsMu sync.RWMutex
+)
+
+// dummyHash is compared against for unknown usernames so they take as long as wrong passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// LoadAccounts reads the local accounts from disk
+func LoadAccounts() error {
+	fb, err := os.ReadFile(filepath.Join(storage.STORAGEDIR, "accounts.json"))
+	if err != nil {
+		return err
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	return json.Unmarshal(fb, &accounts)
+}
+
+// saveAccounts writes the local accounts to disk, callers must hold accountsMu
+func saveAccounts() error {
+	jb, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(storage.STORAGEDIR, "accounts.json"), jb, 0600)
+}
+
+// SetPassword creates a local account or changes its password
+func SetPassword(username, password string) error {
+	username = strings.TrimSpace(username)
+	if !usernameRe.MatchString(username) {
+		return ErrBadUsername
+	}
+	if len(password) < MinPasswordLength {
+		return ErrShortPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	var now = time.Now()
+	a, ok := accounts[username]
+	if !ok {
+		a = Account{Username: username, Created: now}
+	}
+	a.Hash = string(hash)
+	a.Changed = now
+	accounts[username] = a
+	if err = saveAccounts(); err != nil {
+		return err
+	}
+
+	// a new password logs the account out everywhere and revokes its api tokens
+	if ok {
+		return RevokeIdentitySessions(identityKey("local", username))
+	}
+	return nil
+}
+
+// AccountExists returns if there is a local account with this username
+func AccountExists(username string) bool {
+	accountsMu.RLock()
+	defer accountsMu.RUnlock()
+	_, ok := accounts[username]
+	return ok
+}
+
+// DeleteAccount removes a local account and its link
+func DeleteAccount(username string) error {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+
+	if _, ok := accounts[username]; !ok {
+		return ErrNoSuchAccount
+	}
+	delete(accounts, username)
+	if err := saveAccounts(); err != nil {
+		return err
+	}
+
+	if err := UnlinkIdentity(identityKey("local", username)); err != nil && err != ErrNotLinkedIdentity {
+		return err
+	}
+	return RevokeIdentitySessions(identityKey("local", username))
+}
+
+// Accounts lists the local accounts with the players they are linked to
+func Accounts() []AccountView {
+	accountsMu.RLock()
+	defer accountsMu.RUnlock()
+
+	var list = []AccountView{}
+	for _, a := range accounts {
+		var view = AccountView{Username: a.Username, Changed: a.Changed}
+		if id, err := linkedIdentity("local", a.Username); err == nil {
+			view.PlayerName = id.PlayerName
+		}
+		list = append(list, view)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// localProvider logs in with a local account
+type localProvider struct{}
+
+func (localProvider) Name() string  { return "local" }
+func (localProvider) Label() string { return "Username and password" }
+func (localProvider) Kind() string  { return KindPassword }
+
+// Login checks a username and password and returns the linked player
+func (localProvider) Login(username, password string) (Identity, error) {
+	accountsMu.RLock()
+	a, ok := accounts[username]
+	accountsMu.RUnlock()
+
+	var hash = dummyHash
+	if ok {
+		hash = []byte(a.Hash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return Identity{}, ErrBadLogin
+	}
+	return linkedIdentity("local", username)
+}
@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Microsoft is the configuration of the Microsoft account device-code login.
+// The URLs are bases so the whole chain can be pointed at a local stub.
+var Microsoft = struct {
+	ClientID     string
+	LoginURL     string
+	XboxURL      string
+	XSTSURL      string
+	MinecraftURL string
+}{
+	LoginURL:     "https://login.microsoftonline.com/consumers/oauth2/v2.0",
+	XboxURL:      "https://user.auth.xboxlive.com",
+	XSTSURL:      "https://xsts.auth.xboxlive.com",
+	MinecraftURL: "https://api.minecraftservices.com",
+}
+
+// Device login states
+const (
+	DevicePending = "pending"
+	DeviceDone    = "done"
+	DeviceFailed  = "failed"
+)
+
+// ErrNoClientID is returned when the Microsoft login hasn't been configured
+var ErrNoClientID = errors.New("microsoft login is not configured (missing client id)")
+
+var msClient = &http.Client{Timeout: 20 * time.Second}
+
+// DeviceLogin is a Microsoft device-code login in progress. The player opens
+// VerificationURI, enters UserCode and we poll until they're done.
+type DeviceLogin struct {
+	ID              string    `json:"id"`
+	UserCode        string    `json:"usercode"`
+	VerificationURI string    `json:"verificationuri"`
+	Message         string    `json:"message"`
+	ExpiresAt       time.Time `json:"expiresat"`
+	State           string    `json:"state"`
+	Error           string    `json:"error"`
+
+	// set when State is DeviceDone
+	PlayerName string `json:"-"`
+	PlayerUUID string `json:"-"`
+
+	deviceCode string
+	interval   time.Duration
+}
+
+var (
+	deviceLogins   = make(map[string]*DeviceLogin)
+	deviceLoginsMu sync.Mutex
+)
+
+// StartDeviceLogin requests a device code from Microsoft and starts polling for the player to sign in
+func StartDeviceLogin() (DeviceLogin, error) {
+	if Microsoft.ClientID == "" {
+		return DeviceLogin{}, ErrNoClientID
+	}
+
+	var reply struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+		Message         string `json:"message"`
+	}
+	err := postForm(Microsoft.LoginURL+"/devicecode", url.Values{
+		"client_id": {Microsoft.ClientID},
+		"scope":     {"XboxLive.signin offline_access"},
+	}, &reply)
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+
+	var dl = &DeviceLogin{
+		ID:              id,
+		UserCode:        reply.UserCode,
+		VerificationURI: reply.VerificationURI,
+		Message:         reply.Message,
+		ExpiresAt:       time.Now().Add(time.Duration(reply.ExpiresIn) * time.Second),
+		State:           DevicePending,
+		deviceCode:      reply.DeviceCode,
+		interval:        time.Duration(reply.Interval) * time.Second,
+	}
+	if dl.interval <= 0 {
+		dl.interval = 5 * time.Second
+	}
+
+	deviceLoginsMu.Lock()
+	deviceLogins[id] = dl
+	deviceLoginsMu.Unlock()
+
+	go dl.poll()
+	return *dl, nil
+}
+
+// DeviceLoginStatus returns the state of a device login, finished logins are
+// handed out only once
+func DeviceLoginStatus(id string) (DeviceLogin, bool) {
+	deviceLoginsMu.Lock()
+	defer deviceLoginsMu.Unlock()
+
+	dl, ok := deviceLogins[id]
+	if !ok {
+		return DeviceLogin{}, false
+	}
+	if dl.State != DevicePending {
+		delete(deviceLogins, id)
+	}
+	return *dl, true
+}
+
+// poll waits for the player to finish signing in, then exchanges the Microsoft
+// token through Xbox Live and XSTS for a Minecraft token and profile
+func (dl *DeviceLogin) poll() {
+	var msToken string
+	var err error
+	var interval = dl.interval
+	for time.Now().Before(dl.ExpiresAt) {
+		time.Sleep(interval)
+
+		msToken, err = dl.token()
+		if err == errPending {
+			continue
+		}
+		if err == errSlowDown {
+			interval += 5 * time.Second
+			continue
+		}
+		break
+	}
+	if err == nil && msToken == "" {
+		err = errors.New("the login code expired")
+	}
+
+	var name, uuid string
+	if err == nil {
+		name, uuid, err = minecraftLogin(msToken)
+	}
+
+	deviceLoginsMu.Lock()
+	defer deviceLoginsMu.Unlock()
+	if err != nil {
+		dl.State = DeviceFailed
+		dl.Error = err.Error()
+	} else {
+		dl.State = DeviceDone
+		dl.PlayerName = name
+		dl.PlayerUUID = uuid
+	}
+
+	// forget about it if nobody comes asking
+	time.AfterFunc(5*time.Minute, func() {
+		deviceLoginsMu.Lock()
+		delete(deviceLogins, dl.ID)
+		deviceLoginsMu.Unlock()
+	})
+}
+
+var (
+	errPending  = errors.New("authorization pending")
+	errSlowDown = errors.New("slow down")
+)
+
+// token polls the token endpoint once
+func (dl *DeviceLogin) token() (string, error) {
+	var reply struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err := postForm(Microsoft.LoginURL+"/token", url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"client_id":   {Microsoft.ClientID},
+		"device_code": {dl.deviceCode},
+	}, &reply)
+
+	switch reply.Error {
+	case "":
+	case "authorization_pending":
+		return "", errPending
+	case "slow_down":
+		return "", errSlowDown
+	case "authorization_declined":
+		return "", errors.New("the login was declined")
+	case "expired_token":
+		return "", errors.New("the login code expired")
+	default:
+		return "", fmt.Errorf("microsoft login failed: %s", reply.Error)
+	}
+	if err != nil {
+		return "", err
+	}
+	return reply.AccessToken, nil
+}
+
+// xboxReply is the reply of both the Xbox Live and XSTS endpoints
+type xboxReply struct {
+	Token         string `json:"Token"`
+	DisplayClaims struct {
+		Xui []struct {
+			Uhs string `json:"uhs"`
+		} `json:"xui"`
+	} `json:"DisplayClaims"`
+	XErr int64 `json:"XErr"`
+}
+
+// minecraftLogin turns a Microsoft access token into the player's Minecraft profile,
+// the Minecraft access token is only used for the profile lookup and never kept
+func minecraftLogin(msToken string) (name, uuid string, err error) {
+	var xbl, xsts xboxReply
+	err = postJSON(Microsoft.XboxURL+"/user/authenticate", map[string]interface{}{
+		"Properties": map[string]interface{}{
+			"AuthMethod": "RPS",
+			"SiteName":   "user.auth.xboxlive.com",
+			"RpsTicket":  "d=" + msToken,
+		},
+		"RelyingParty": "http://auth.xboxlive.com",
+		"TokenType":    "JWT",
+	}, "", &xbl)
+	if err != nil {
+		return "", "", fmt.Errorf("xbox live login failed: %s", err.Error())
+	}
+
+	err = postJSON(Microsoft.XSTSURL+"/xsts/authorize", map[string]interface{}{
+		"Properties": map[string]interface{}{
+			"SandboxId":  "RETAIL",
+			"UserTokens": []string{xbl.Token},
+		},
+		"RelyingParty": "rp://api.minecraftservices.com/",
+		"TokenType":    "JWT",
+	}, "", &xsts)
+	if xsts.XErr != 0 {
+		return "", "", xstsError(xsts.XErr)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("xsts login failed: %s", err.Error())
+	}
+	if len(xsts.DisplayClaims.Xui) == 0 {
+		return "", "", errors.New("xsts login failed: no user hash")
+	}
+
+	var mc struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = postJSON(Microsoft.MinecraftURL+"/authentication/login_with_xbox", map[string]string{
+		"identityToken": fmt.Sprintf("XBL3.0 x=%s;%s", xsts.DisplayClaims.Xui[0].Uhs, xsts.Token),
+	}, "", &mc)
+	if err != nil {
+		return "", "", fmt.Errorf("minecraft login failed: %s", err.Error())
+	}
+
+	var profile struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	err = getJSON(Microsoft.MinecraftURL+"/minecraft/profile", mc.AccessToken, &profile)
+	if err != nil || profile.Name == "" {
+		return "", "", errors.New("this account doesn't own minecraft (no profile)")
+	}
+
+	return profile.Name, dashUUID(profile.ID), nil
+}
+
+// xstsError explains the XSTS errors players can do something about
+func xstsError(code int64) error {
+	switch code {
+	case 2148916233:
+		return errors.New("this microsoft account has no xbox profile, sign in at minecraft.net first")
+	case 2148916235:
+		return errors.New("xbox live is not available in your country")
+	case 2148916236, 2148916237:
+		return errors.New("this account needs adult verification on xbox.com")
+	case 2148916238:
+		return errors.New("child accounts must be added to a family by an adult")
+	}
+	return fmt.Errorf("xsts login failed (XErr %d)", code)
+}
+
+func postForm(endpoint string, form url.Values, reply interface{}) error {
+	resp, err := msClient.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+	return decodeReply(resp, reply)
+}
+
+func postJSON(endpoint string, body interface{}, bearer string, reply interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := msClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeReply(resp, reply)
+}
+
+func getJSON(endpoint, bearer string, reply interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := msClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return decodeReply(resp, reply)
+}
+
+// decodeReply decodes a JSON reply (error replies too, they carry the details)
+// and returns an error for non-2xx statuses
+func decodeReply(resp *http.Response, reply interface{}) error {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if len(body) > 0 && strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		if jerr := json.Unmarshal(body, reply); jerr != nil && resp.StatusCode < 300 {
+			return jerr
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// randomID returns a hard to guess id
+func randomID() (string, error) {
+	var b = make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// OIDC is the configuration of the generic OpenID Connect login. The player
+// comes from PlayerClaim when it's set and present, otherwise from a link.
+// PlayerClaim must be a claim the identity provider controls, one users can
+// edit themselves (often preferred_username or nickname) lets anyone log in
+// as any player.
+var OIDC = struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	PlayerClaim  string
+	Label        string
+}{
+	Label: "Single sign-on",
+}
+
+// ErrNoIssuer is returned when the OIDC login hasn't been configured
+var ErrNoIssuer = errors.New("oidc login is not configured (missing issuer)")
+
+// oidcEndpoints is the part of the issuer's discovery document we use
+type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+var (
+	discovered   *oidcEndpoints
+	discoveredMu sync.Mutex
+)
+
+// discover fetches (once) the issuer's endpoints
+func discover() (oidcEndpoints, error) {
+	discoveredMu.Lock()
+	defer discoveredMu.Unlock()
+
+	if discovered != nil {
+		return *discovered, nil
+	}
+	if OIDC.Issuer == "" || OIDC.ClientID == "" {
+		return oidcEndpoints{}, ErrNoIssuer
+	}
+
+	var ep oidcEndpoints
+	err := getJSON(OIDC.Issuer+"/.well-known/openid-configuration", "", &ep)
+	if err != nil {
+		return oidcEndpoints{}, err
+	}
+	if strings.TrimSuffix(ep.Issuer, "/") != OIDC.Issuer {
+		return oidcEndpoints{}, fmt.Errorf("issuer mismatch in discovery document: %s", ep.Issuer)
+	}
+	if ep.AuthorizationEndpoint == "" || ep.TokenEndpoint == "" || ep.JwksURI == "" {
+		return oidcEndpoints{}, fmt.Errorf("discovery document is missing endpoints")
+	}
+
+	discovered = &ep
+	return ep, nil
+}
+
+// RedirectLogin ties an identity provider's callback to the browser that started
+// the login: the state, the nonce the id token must carry and the PKCE code verifier
+type RedirectLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewRedirectLogin returns fresh random values for a redirect login
+func NewRedirectLogin() (RedirectLogin, error) {
+	var l RedirectLogin
+	var err error
+	for _, v := range []*string{&l.State, &l.Nonce, &l.Verifier} {
+		if *v, err = randomToken(); err != nil {
+			return RedirectLogin{}, err
+		}
+	}
+	return l, nil
+}
+
+// challenge is the PKCE S256 code challenge of the verifier
+func (l RedirectLogin) challenge() string {
+	sum := sha256.Sum256([]byte(l.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomToken returns 32 random bytes as base64url (43 characters, long enough for a PKCE verifier)
+func randomToken() (string, error) {
+	var b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oidcProvider logs in with an OpenID Connect identity provider (authorization code flow with PKCE)
+type oidcProvider struct{}
+
+func (oidcProvider) Name() string  { return "oidc" }
+func (oidcProvider) Label() string { return OIDC.Label }
+func (oidcProvider) Kind() string  { return KindRedirect }
+
+// AuthURL returns where to send the browser to log in
+func (oidcProvider) AuthURL(login RedirectLogin) (string, error) {
+	ep, err := discover()
+	if err != nil {
+		return "", err
+	}
+
+	var q = url.Values{
+		"response_type":         {"code"},
+		"client_id":             {OIDC.ClientID},
+		"redirect_uri":          {OIDC.RedirectURL},
+		"scope":                 {"openid profile"},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {login.challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	var sep = "?"
+	if strings.Contains(ep.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return ep.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange trades the authorization code (and PKCE verifier) for tokens and
+// verifies the id token, whose subject is who logged in. The userinfo endpoint
+// is only asked for PlayerClaim when the id token doesn't carry it.
+func (oidcProvider) Exchange(code string, login RedirectLogin) (Identity, error) {
+	ep, err := discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	err = postForm(ep.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {OIDC.RedirectURL},
+		"client_id":     {OIDC.ClientID},
+		"client_secret": {OIDC.ClientSecret},
+		"code_verifier": {login.Verifier},
+	}, &token)
+	if err != nil {
+		if token.Error != "" {
+			return Identity{}, fmt.Errorf("%s: %s", token.Error, token.Description)
+		}
+		return Identity{}, err
+	}
+
+	if token.IDToken == "" {
+		return Identity{}, fmt.Errorf("token reply has no id token")
+	}
+	claims, err := verifyIDToken(token.IDToken, ep.JwksURI, login.Nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+	subject := claims["sub"].(string)
+
+	if OIDC.PlayerClaim != "" {
+		player, _ := claims[OIDC.PlayerClaim].(string)
+		if player == "" && ep.UserinfoEndpoint != "" && token.AccessToken != "" {
+			var info map[string]interface{}
+			if err = getJSON(ep.UserinfoEndpoint, token.AccessToken, &info); err != nil {
+				return Identity{}, err
+			}
+			if info["sub"] != subject {
+				return Identity{}, fmt.Errorf("userinfo subject does not match the id token")
+			}
+			player, _ = info[OIDC.PlayerClaim].(string)
+		}
+		if player != "" {
+			uuid, err := PlayerUUID(player)
+			if err != nil {
+				return Identity{}, err
+			}
+			return Identity{Provider: "oidc", Subject: subject, PlayerName: player, PlayerUUID: uuid}, nil
+		}
+	}
+	return linkedIdentity("oidc", subject)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// players maps known player names to their Mojang UUIDs, learned at login and from lookups
+// so that ownership can follow a player across name changes
+var (
+	players   = make(map[string]string)
+	playersMu sync.RWMutex
+)
+
+// LoadPlayers reads the known players from disk
+func LoadPlayers() error {
+	fb, err := os.ReadFile(filepath.Join(storage.STORAGEDIR, "players.json"))
+	if err != nil {
+		return err
+	}
+
+	playersMu.Lock()
+	defer playersMu.Unlock()
+	return json.Unmarshal(fb, &players)
+}
+
+// savePlayers writes the known players to disk, callers must hold playersMu
+func savePlayers() error {
+	jb, err := json.MarshalIndent(players, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(storage.STORAGEDIR, "players.json"), jb, 0600)
+}
+
+// RememberPlayer records the current name of a player UUID, forgetting any previous name
+func RememberPlayer(name, uuid string) {
+	uuid = dashUUID(uuid)
+	if name == "" || uuid == "" {
+		return
+	}
+
+	playersMu.Lock()
+	defer playersMu.Unlock()
+
+	if players[name] == uuid {
+		return
+	}
+	for n, u := range players {
+		if u == uuid {
+			delete(players, n)
+		}
+	}
+	players[name] = uuid
+	if err := savePlayers(); err != nil {
+		fmt.Printf("unable to save players: %s\n", err.Error())
+	}
+}
+
+// KnownUUID returns the UUID of a player seen before (no network lookup), or an empty string
+func KnownUUID(name string) string {
+	playersMu.RLock()
+	defer playersMu.RUnlock()
+	return players[name]
+}
+
+// KnownName returns the most recent name of a player UUID, or an empty string
+func KnownName(uuid string) string {
+	playersMu.RLock()
+	defer playersMu.RUnlock()
+	for n, u := range players {
+		if u == uuid {
+			return n
+		}
+	}
+	return ""
+}
+
+// PlayerUUID returns the UUID of a player, looking it up at Mojang if it isn't known yet
+func PlayerUUID(name string) (string, error) {
+	if uuid := KnownUUID(name); uuid != "" {
+		return uuid, nil
+	}
+
+	uuid, err := PlayerUUIDLookup(name)
+	if err != nil {
+		return "", err
+	}
+	RememberPlayer(name, uuid)
+	return uuid, nil
+}
+
+// dashUUID formats an undashed Mojang UUID in the usual 8-4-4-4-12 form
+func dashUUID(id string) string {
+	id = strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	if len(id) != 32 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-%s", id[0:8], id[8:12], id[12:16], id[16:20], id[20:])
+}
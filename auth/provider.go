@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provider kinds, they tell the login form how to drive a provider
+const (
+	KindDevice   = "device"
+	KindPassword = "password"
+	KindRedirect = "redirect"
+)
+
+// Identity is a logged in user as vouched for by a provider. Permissions are
+// always checked against the linked Minecraft player.
+type Identity struct {
+	Provider   string
+	Subject    string
+	PlayerName string
+	PlayerUUID string
+}
+
+// Provider is a way of logging in to the manager
+type Provider interface {
+	Name() string
+	Label() string
+	Kind() string
+}
+
+// PasswordProvider checks a username and password
+type PasswordProvider interface {
+	Provider
+	Login(username, password string) (Identity, error)
+}
+
+// RedirectProvider sends the browser to an identity provider and gets a code back
+type RedirectProvider interface {
+	Provider
+	AuthURL(login RedirectLogin) (string, error)
+	Exchange(code string, login RedirectLogin) (Identity, error)
+}
+
+// ProviderInfo describes an enabled provider to the login form
+type ProviderInfo struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Kind  string `json:"kind"`
+}
+
+// available are all the providers that can be enabled
+var available = []Provider{
+	microsoftProvider{},
+	localProvider{},
+	oidcProvider{},
+}
+
+// enabled are the providers offered on the login form, in order
+var enabled []Provider
+
+// EnableProviders turns on the (comma separated) named login providers
+func EnableProviders(names string) error {
+	enabled = nil
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var found bool
+		for _, p := range available {
+			if p.Name() == name {
+				enabled = append(enabled, p)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown login provider %q", name)
+		}
+	}
+	return nil
+}
+
+// GetProvider returns an enabled provider by name
+func GetProvider(name string) (Provider, bool) {
+	for _, p := range enabled {
+		if p.Name() == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Providers describes the enabled providers
+func Providers() []ProviderInfo {
+	var list = []ProviderInfo{}
+	for _, p := range enabled {
+		list = append(list, ProviderInfo{Name: p.Name(), Label: p.Label(), Kind: p.Kind()})
+	}
+	return list
+}
+
+// microsoftProvider is the Microsoft account device-code login, the player
+// logs in as themselves so no link is needed
+type microsoftProvider struct{}
+
+func (microsoftProvider) Name() string  { return "microsoft" }
+func (microsoftProvider) Label() string { return "Microsoft" }
+func (microsoftProvider) Kind() string  { return KindDevice }
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// SessionLifetime is how long a login stays valid
+var SessionLifetime = 7 * 24 * time.Hour
+
+// lastSeenEvery limits how often using a session rewrites the sessions file
+const lastSeenEvery = 5 * time.Minute
+
+// ErrNoSuchSession is returned when revoking a session that doesn't exist (or isn't yours)
+var ErrNoSuchSession = errors.New("no such session")
+
+// Session is a login issued by mcmanager. Only a hash of the session token is
+// kept, the token itself lives in the browser's cookie. CSRF must come back with
+// every request that changes something.
+type Session struct {
+	ID        string    `json:"id"`
+	CSRF      string    `json:"csrf,omitempty"`
+	Player    string    `json:"player"`
+	Identity  string    `json:"identity"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastseen"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"useragent"`
+	IP        string    `json:"ip"`
+}
+
+// SessionView is a session as shown to its player
+type SessionView struct {
+	Session
+	Current bool `json:"current"`
+}
+
+// sessions maps token hashes to sessions
+var (
+	sessions   = make(map[string]*Session)
+	sessionsMu sync.Mutex
+)
+
+// hashToken returns the key a session token is stored under
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadSessions reads the sessions from disk, expired ones are dropped
+func LoadSessions() error {
+	fb, err := os.ReadFile(filepath.Join(storage.STORAGEDIR, "sessions.json"))
+	if err != nil {
+		return err
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if err = json.Unmarshal(fb, &sessions); err != nil {
+		return err
+	}
+	return saveSessions()
+}
+
+// saveSessions writes the sessions to disk, callers must hold sessionsMu
+func saveSessions() error {
+	var now = time.Now()
+	for hash, s := range sessions {
+		if now.After(s.Expires) {
+			delete(sessions, hash)
+		}
+	}
+
+	jb, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(storage.STORAGEDIR, "sessions.json"), jb, 0600)
+}
+
+// NewSession logs a player in and returns the session token for their cookie
+func NewSession(id Identity, userAgent, ip string) (string, Session, error) {
+	var b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Session{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	sid, err := randomID()
+	if err != nil {
+		return "", Session{}, err
+	}
+	csrf, err := randomID()
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	var now = time.Now()
+	var s = &Session{
+		ID:        sid,
+		CSRF:      csrf,
+		Player:    id.PlayerName,
+		Identity:  identityKey(id.Provider, id.Subject),
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(SessionLifetime),
+		UserAgent: userAgent,
+		IP:        ip,
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessions[hashToken(token)] = s
+	return token, *s, saveSessions()
+}
+
+// VerifySession checks the player name and session token of a request
+func VerifySession(player, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s, ok := sessions[hashToken(token)]
+	if !ok || s.Player != player {
+		return false
+	}
+
+	var now = time.Now()
+	if now.After(s.Expires) {
+		delete(sessions, hashToken(token))
+		saveSessions()
+		return false
+	}
+	if now.Sub(s.LastSeen) > lastSeenEvery {
+		s.LastSeen = now
+		saveSessions()
+	}
+	return true
+}
+
+// SessionCSRF returns the CSRF token of a session, sessions from before CSRF
+// tokens existed get one now
+func SessionCSRF(token string) string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	s, ok := sessions[hashToken(token)]
+	if !ok {
+		return ""
+	}
+	if s.CSRF == "" {
+		csrf, err := randomID()
+		if err != nil {
+			return ""
+		}
+		s.CSRF = csrf
+		saveSessions()
+	}
+	return s.CSRF
+}
+
+// SessionIdentity returns the identity a session logged in with
+func SessionIdentity(token string) string {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if s, ok := sessions[hashToken(token)]; ok {
+		return s.Identity
+	}
+	return ""
+}
+
+// EndSession revokes the session a token belongs to (logout)
+func EndSession(token string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if _, ok := sessions[hashToken(token)]; ok {
+		delete(sessions, hashToken(token))
+		saveSessions()
+	}
+}
+
+// PlayerSessions lists a player's sessions, newest first, marking the one the token belongs to
+func PlayerSessions(player, token string) []SessionView {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	var current = hashToken(token)
+	var list = []SessionView{}
+	for hash, s := range sessions {
+		if s.Player == player && time.Now().Before(s.Expires) {
+			var view = SessionView{Session: *s, Current: hash == current}
+			view.CSRF = ""
+			list = append(list, view)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+// RevokeSession revokes one of a player's sessions by its id
+func RevokeSession(player, id string) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for hash, s := range sessions {
+		if s.ID == id && s.Player == player {
+			delete(sessions, hash)
+			return saveSessions()
+		}
+	}
+	return ErrNoSuchSession
+}
+
+// RevokePlayerSessions revokes every session of a player (log out everywhere)
+func RevokePlayerSessions(player string) error {
+	return revokeSessions(func(s *Session) bool { return s.Player == player })
+}
+
+// RevokeIdentitySessions revokes every session logged in with an identity and the
+// API tokens made with them, used when its password, link or account changes
+func RevokeIdentitySessions(identity string) error {
+	if err := revokeSessions(func(s *Session) bool { return s.Identity == identity }); err != nil {
+		return err
+	}
+	return revokeIdentityAPITokens(identity)
+}
+
+func revokeSessions(match func(s *Session) bool) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	var changed bool
+	for hash, s := range sessions {
+		if match(s) {
+			delete(sessions, hash)
+			changed = true
+		}
+	}
+	if changed {
+		return saveSessions()
+	}
+	return nil
+}
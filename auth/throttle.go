@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttle is how failed logins are slowed down. Every failure after FreeFailures
+// doubles the wait before the next attempt (from BaseDelay up to MaxDelay), and
+// LockAfter failures lock the client, or the client for that username, out for
+// Lockout. A username on its own is only ever slowed down so nobody else can
+// lock its owner out. Failures are forgotten after Window without any.
+var Throttle = struct {
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	Lockout      time.Duration
+	Window       time.Duration
+	PerMinute    int
+}{
+	FreeFailures: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	Lockout:      15 * time.Minute,
+	Window:       time.Hour,
+	PerMinute:    20,
+}
+
+// ThrottleError is returned when a login is refused without checking it
+type ThrottleError struct {
+	Wait   time.Duration
+	Locked bool
+}
+
+func (e ThrottleError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed logins, locked out for %s", e.Wait.Round(time.Second))
+	}
+	return fmt.Sprintf("too many login attempts, try again in %s", e.Wait.Round(time.Second))
+}
+
+// attempts is what we remember about a client address or username
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	nextAllowed time.Time
+	lockedUntil time.Time
+
+	windowStart time.Time
+	inWindow    int
+}
+
+var (
+	throttled   = make(map[string]*attempts)
+	throttledMu sync.Mutex
+)
+
+// throttleKey is a key a login attempt is counted under, lock is whether
+// failures may lock it out rather than only delay it
+type throttleKey struct {
+	name string
+	lock bool
+}
+
+// throttleKeys are the keys a login attempt is counted under, the client comes first
+func throttleKeys(ip, username string) []throttleKey {
+	var keys = []throttleKey{{"ip:" + ip, true}}
+	if username != "" {
+		username = strings.ToLower(username)
+		keys = append(keys, throttleKey{"login:" + ip + "/" + username, true}, throttleKey{"user:" + username, false})
+	}
+	return keys
+}
+
+// LoginAllowed checks if a client (and username, when there is one) may try to
+// log in now. Every call counts towards the per-minute limit of the client.
+func LoginAllowed(ip, username string) error {
+	throttledMu.Lock()
+	defer throttledMu.Unlock()
+
+	var now = time.Now()
+	var refused ThrottleError
+	for i, key := range throttleKeys(ip, username) {
+		a := throttled[key.name]
+		if a == nil {
+			a = &attempts{}
+			throttled[key.name] = a
+		}
+		if now.Sub(a.lastFailure) > Throttle.Window && now.After(a.lockedUntil) {
+			a.failures = 0
+		}
+
+		if now.Before(a.lockedUntil) {
+			refused = ThrottleError{Wait: a.lockedUntil.Sub(now), Locked: true}
+		} else if now.Before(a.nextAllowed) && refused.Wait == 0 {
+			refused = ThrottleError{Wait: a.nextAllowed.Sub(now)}
+		}
+
+		// the per-minute limit is per client only
+		if i == 0 && Throttle.PerMinute > 0 {
+			if now.Sub(a.windowStart) > time.Minute {
+				a.windowStart = now
+				a.inWindow = 0
+			}
+			a.inWindow++
+			if a.inWindow > Throttle.PerMinute && refused.Wait == 0 {
+				refused = ThrottleError{Wait: a.windowStart.Add(time.Minute).Sub(now)}
+			}
+		}
+	}
+
+	pruneThrottled(now)
+	if refused.Wait > 0 {
+		return refused
+	}
+	return nil
+}
+
+// LoginFailed counts a failed login, it returns true when this failure locked
+// the client (or the client for this username) out
+func LoginFailed(ip, username string) bool {
+	throttledMu.Lock()
+	defer throttledMu.Unlock()
+
+	var now = time.Now()
+	var locked bool
+	for _, key := range throttleKeys(ip, username) {
+		a := throttled[key.name]
+		if a == nil {
+			a = &attempts{}
+			throttled[key.name] = a
+		}
+		a.failures++
+		a.lastFailure = now
+
+		if key.lock && Throttle.LockAfter > 0 && a.failures >= Throttle.LockAfter {
+			a.lockedUntil = now.Add(Throttle.Lockout)
+			a.failures = 0
+			locked = true
+		} else if a.failures > Throttle.FreeFailures {
+			delay := Throttle.BaseDelay << uint(a.failures-Throttle.FreeFailures-1)
+			if delay > Throttle.MaxDelay || delay <= 0 {
+				delay = Throttle.MaxDelay
+			}
+			a.nextAllowed = now.Add(delay)
+		}
+	}
+
+	pruneThrottled(now)
+	return locked
+}
+
+// LoginSucceeded forgets the failures of a client and username
+func LoginSucceeded(ip, username string) {
+	throttledMu.Lock()
+	defer throttledMu.Unlock()
+
+	for _, key := range throttleKeys(ip, username) {
+		if a := throttled[key.name]; a != nil && time.Now().After(a.lockedUntil) {
+			a.failures = 0
+			a.nextAllowed = time.Time{}
+		}
+	}
+}
+
+// pruneThrottled drops entries with nothing left to remember, callers must hold throttledMu
+func pruneThrottled(now time.Time) {
+	if len(throttled) < 1000 {
+		return
+	}
+	for key, a := range throttled {
+		if now.Sub(a.lastFailure) > Throttle.Window && now.After(a.lockedUntil) && now.Sub(a.windowStart) > time.Minute {
+			delete(throttled, key)
+		}
+	}
+}
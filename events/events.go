@@ -0,0 +1,70 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	ServerAdded     = "server_added"
+	ServerUpdated   = "server_updated"
+	ServerRemoved   = "server_removed"
+	ServerStarted   = "server_started"
+	ServerStopped   = "server_stopped"
+	PlayerJoined    = "player_joined"
+	PlayerLeft      = "player_left"
+	BackupCompleted = "backup_completed"
+	JobProgress     = "job_progress"
+)
+
+// Event is something that happened inside mcmanager that the UI may want to know about
+type Event struct {
+	Type     string      `json:"type"`
+	ServerID string      `json:"serverid,omitempty"`
+	JobID    string      `json:"jobid,omitempty"`
+	Player   string      `json:"player,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Time     time.Time   `json:"time"`
+}
+
+var (
+	subscribers = make(map[chan Event]bool)
+	mu          sync.RWMutex
+)
+
+// Publish sends an event to all subscribers
+// slow subscribers miss events rather than blocking the publisher
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function to stop receiving them
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	mu.Lock()
+	subscribers[ch] = true
+	mu.Unlock()
+
+	return ch, func() {
+		mu.Lock()
+		if subscribers[ch] {
+			delete(subscribers, ch)
+			close(ch)
+		}
+		mu.Unlock()
+	}
+}
@@ -7,9 +7,11 @@ type NewServer struct {
 	Flavor    string `form:"flavor"`
 	GameMode  string `form:"gamemode"`
 	Hardcore  bool   `form:"hardcore"`
+	Memory    string `form:"memory"`
 	MOTD      string `form:"motd"`
 	Name      string `form:"name"`
 	Page      string `form:"page"`
+	Port      int    `form:"port"`
 	PVP       bool   `form:"pvp"`
 	StartNow  bool   `form:"startnow"`
 	Whitelist bool   `form:"whitelist"`
@@ -33,3 +35,84 @@ type AddOp struct {
 type WhitelistAdd struct {
 	PlayerName string `form:"playername"`
 }
+
+// Purge is the structure of the data expected from the purge confirmation
+type Purge struct {
+	Confirm string `form:"confirm"`
+	Archive bool   `form:"archive"`
+}
+
+// Clone is the structure of the data expected from the clone server web form
+type Clone struct {
+	Name   string `form:"name"`
+	Commit string `form:"commit"`
+	Port   int    `form:"port"`
+}
+
+// Edit is the structure of the data expected from the edit server web form
+type Edit struct {
+	Name        string `form:"name"`
+	Description string `form:"description"`
+	Tags        string `form:"tags"`
+	Notes       string `form:"notes"`
+}
+
+// Transfer is the structure of the data expected from the transfer ownership web form
+type Transfer struct {
+	PlayerName string `form:"playername"`
+	Keep       bool   `form:"keep"`
+}
+
+// CoOwner is the structure of the data expected from the co-owner add/remove web forms
+type CoOwner struct {
+	PlayerName string `form:"playername"`
+}
+
+// Grant is the structure of the data expected from the grant role web form, an empty role revokes
+type Grant struct {
+	PlayerName string `form:"playername"`
+	Role       string `form:"role"`
+}
+
+// Role is the structure of the data expected from the custom role web form, no actions deletes the role
+type Role struct {
+	Role    string   `form:"role"`
+	Actions []string `form:"actions"`
+}
+
+// AdminEdit is the structure of the data expected from the admin list web form
+type AdminEdit struct {
+	PlayerName string `form:"playername"`
+	Remove     bool   `form:"remove"`
+}
+
+// Account is the structure of the data expected from the local account admin form,
+// an empty password leaves the password alone
+type Account struct {
+	Username   string `form:"username"`
+	Password   string `form:"password"`
+	PlayerName string `form:"playername"`
+	Remove     bool   `form:"remove"`
+}
+
+// Link is the structure of the data expected from the identity link admin form
+type Link struct {
+	Identity   string `form:"identity"`
+	PlayerName string `form:"playername"`
+	Remove     bool   `form:"remove"`
+}
+
+// Revoke is the structure of the data expected when revoking a session
+type Revoke struct {
+	ID string `form:"id"`
+}
+
+// APIToken is the structure of the data expected from the new API token web form,
+// no servers or actions means all of them
+type APIToken struct {
+	Name     string   `form:"name"`
+	ReadOnly bool     `form:"readonly"`
+	Servers  []string `form:"servers"`
+	Actions  []string `form:"actions"`
+	Days     int      `form:"days"`
+}
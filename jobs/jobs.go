@@ -0,0 +1,356 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jlmeeker/mcmanager/events"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Job states
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+)
+
+// MaxLogLines is how many log and progress lines are kept per job
+var MaxLogLines = 500
+
+// MaxLineLength is how long a single log line may be before it is cut short
+var MaxLineLength = 1024
+
+// SaveInterval is how often a job producing command output is written to disk
+var SaveInterval = 2 * time.Second
+
+// KeepFinished is how long finished jobs are kept before they are pruned
+var KeepFinished = 7 * 24 * time.Hour
+
+// ErrNotFound is returned when a job ID is unknown
+var ErrNotFound = errors.New("job not found")
+
+// Message is a timestamped progress or log line
+type Message struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// Job is a long-running operation (create, upgrade, regen, spigot build...) run in the background
+type Job struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	ServerID string    `json:"serverid"`
+	Owner    string    `json:"owner"`
+	State    string    `json:"state"`
+	Progress []Message `json:"progress"`
+	Log      []Message `json:"log"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// Task is the handle a running job uses to report progress, it is safe for concurrent use
+type Task struct {
+	mu      sync.Mutex
+	job     Job
+	partial string
+	saved   time.Time
+	saveMu  sync.Mutex
+}
+
+var (
+	tasks = make(map[string]*Task)
+	mu    sync.Mutex
+)
+
+// Load reads persisted jobs, any that were running when we last exited are marked failed
+func Load() error {
+	entries, err := os.ReadDir(storage.JOBDIR)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(storage.JOBDIR, entry.Name()))
+		if err != nil {
+			log.Printf("jobs: unable to read %s: %s", entry.Name(), err.Error())
+			continue
+		}
+
+		var t = &Task{}
+		err = json.Unmarshal(b, &t.job)
+		if err != nil {
+			log.Printf("jobs: unable to parse %s: %s", entry.Name(), err.Error())
+			continue
+		}
+
+		if !t.job.Done() {
+			t.job.State = StateFailed
+			t.job.Error = "interrupted by mcmanager restart"
+			t.job.Finished = time.Now()
+			t.save()
+		}
+		tasks[t.job.ID] = t
+	}
+	prune()
+
+	return nil
+}
+
+// Run creates a job and runs fn in the background, returning immediately
+func Run(kind, serverID, owner string, fn func(t *Task) error) Job {
+	var t = &Task{
+		job: Job{
+			ID:       uuid.New().String(),
+			Kind:     kind,
+			ServerID: serverID,
+			Owner:    owner,
+			State:    StatePending,
+			Progress: []Message{},
+			Log:      []Message{},
+			Created:  time.Now(),
+		},
+	}
+
+	mu.Lock()
+	prune()
+	tasks[t.job.ID] = t
+	mu.Unlock()
+	t.save()
+
+	go func() {
+		t.mu.Lock()
+		t.job.State = StateRunning
+		t.job.Started = time.Now()
+		t.mu.Unlock()
+		t.Report("started " + kind)
+
+		err := fn(t)
+
+		t.mu.Lock()
+		t.flush()
+		t.job.Finished = time.Now()
+		if err != nil {
+			t.job.State = StateFailed
+			t.job.Error = err.Error()
+		} else {
+			t.job.State = StateDone
+		}
+		t.mu.Unlock()
+		t.Report("finished " + kind)
+	}()
+
+	return t.snapshot()
+}
+
+// Get returns a snapshot of a job
+func Get(id string) (Job, error) {
+	mu.Lock()
+	t, ok := tasks[id]
+	mu.Unlock()
+
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return t.snapshot(), nil
+}
+
+// List returns snapshots of all jobs owned by owner, newest first
+func List(owner string) []Job {
+	mu.Lock()
+	var result = []Job{}
+	for _, t := range tasks {
+		snap := t.snapshot()
+		if snap.Owner == owner {
+			result = append(result, snap)
+		}
+	}
+	mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+	return result
+}
+
+// prune forgets finished jobs older than KeepFinished and removes their files (caller must hold mu)
+func prune() {
+	cutoff := time.Now().Add(-KeepFinished)
+	for id, t := range tasks {
+		snap := t.snapshot()
+		if !snap.Done() || snap.Finished.After(cutoff) {
+			continue
+		}
+
+		t.saveMu.Lock()
+		err := os.Remove(filepath.Join(storage.JOBDIR, id+".json"))
+		t.saveMu.Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("jobs: unable to remove %s: %s", id, err.Error())
+			continue
+		}
+		delete(tasks, id)
+	}
+}
+
+// ID returns the job ID
+func (t *Task) ID() string {
+	return t.job.ID
+}
+
+// Report adds a progress message
+func (t *Task) Report(text string) {
+	t.mu.Lock()
+	t.job.Progress = append(t.job.Progress, Message{Time: time.Now(), Text: truncate(text)})
+	if len(t.job.Progress) > MaxLogLines {
+		t.job.Progress = t.job.Progress[len(t.job.Progress)-MaxLogLines:]
+	}
+	t.mu.Unlock()
+	t.save()
+
+	snap := t.snapshot()
+	events.Publish(events.Event{
+		Type:     events.JobProgress,
+		ServerID: snap.ServerID,
+		JobID:    snap.ID,
+		Player:   snap.Owner,
+		Data: map[string]string{
+			"kind":  snap.Kind,
+			"state": snap.State,
+			"text":  text,
+			"error": snap.Error,
+		},
+	})
+}
+
+// SetResult sets the value handed back to the caller when the job is done (e.g. a new server ID)
+func (t *Task) SetResult(result string) {
+	t.mu.Lock()
+	t.job.Result = result
+	t.mu.Unlock()
+	t.save()
+}
+
+// Write lets a task be used as the output of a command, each line becomes a log entry
+func (t *Task) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.partial += string(p)
+	for {
+		ndx := strings.IndexByte(t.partial, '\n')
+		if ndx < 0 {
+			break
+		}
+		t.appendLog(strings.TrimRight(t.partial[:ndx], "\r"))
+		t.partial = t.partial[ndx+1:]
+	}
+	if len(t.partial) > MaxLineLength {
+		t.appendLog(t.partial)
+		t.partial = ""
+	}
+	t.mu.Unlock()
+	t.saveThrottled()
+	return len(p), nil
+}
+
+// Logf adds a formatted log line
+func (t *Task) Logf(format string, args ...interface{}) {
+	t.mu.Lock()
+	t.appendLog(fmt.Sprintf(format, args...))
+	t.mu.Unlock()
+	t.saveThrottled()
+}
+
+// appendLog adds a log line, trimming the oldest ones (caller must hold t.mu)
+func (t *Task) appendLog(text string) {
+	t.job.Log = append(t.job.Log, Message{Time: time.Now(), Text: truncate(text)})
+	if len(t.job.Log) > MaxLogLines {
+		t.job.Log = t.job.Log[len(t.job.Log)-MaxLogLines:]
+	}
+}
+
+// truncate cuts a line down to MaxLineLength
+func truncate(text string) string {
+	if len(text) <= MaxLineLength {
+		return text
+	}
+	return strings.ToValidUTF8(text[:MaxLineLength], "") + "..."
+}
+
+// flush writes out any partial log line (caller must hold t.mu)
+func (t *Task) flush() {
+	if t.partial != "" {
+		t.appendLog(t.partial)
+		t.partial = ""
+	}
+}
+
+// Done returns whether the job has finished (successfully or not)
+func (j Job) Done() bool {
+	return j.State == StateDone || j.State == StateFailed
+}
+
+func (t *Task) snapshot() Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var snap = t.job
+	snap.Progress = append([]Message{}, t.job.Progress...)
+	snap.Log = append([]Message{}, t.job.Log...)
+	return snap
+}
+
+// saveThrottled persists a job at most once every SaveInterval, the final
+// Report of a job always saves so nothing is lost when it finishes
+func (t *Task) saveThrottled() {
+	t.mu.Lock()
+	due := time.Since(t.saved) >= SaveInterval
+	t.mu.Unlock()
+	if due {
+		t.save()
+	}
+}
+
+// save persists a job so it survives restarts and page reloads, saves of a
+// task are serialized and go through a temporary file so a reader never sees
+// a partial write
+func (t *Task) save() {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+
+	t.mu.Lock()
+	t.saved = time.Now()
+	t.mu.Unlock()
+
+	snap := t.snapshot()
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err == nil {
+		path := filepath.Join(storage.JOBDIR, snap.ID+".json")
+		err = os.WriteFile(path+".tmp", b, storage.DEFAULTFILEPERM)
+		if err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		log.Printf("jobs: unable to save %s: %s", snap.ID, err.Error())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"embed"
 	"flag"
 	"fmt"
@@ -8,12 +9,20 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/jobs"
 	"github.com/jlmeeker/mcmanager/mcmhttp"
+	"github.com/jlmeeker/mcmanager/metrics"
 	"github.com/jlmeeker/mcmanager/paper"
+	"github.com/jlmeeker/mcmanager/ports"
+	"github.com/jlmeeker/mcmanager/proxy"
 	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/sessions"
+	"github.com/jlmeeker/mcmanager/status"
 	"github.com/jlmeeker/mcmanager/storage"
 	"github.com/jlmeeker/mcmanager/vanilla"
 )
@@ -24,11 +33,48 @@ var embededFiles embed.FS
 // APPTITLE is the name of the application as shows in WebUI
 const APPTITLE = "MC Manager"
 
+// VERSION is set at build time with -ldflags "-X main.VERSION=..."
+var VERSION = "dev"
+
 // Flags
 var (
-	flagHostName   = flag.String("hostname", "", "hostname to display for server instance addresses (empty will use OS hostname)")
-	flagStorageDir = flag.String("storage", "", "where to store server data")
-	flagListenAddr = flag.String("listen", "127.0.0.1:8080", "address to listen for http traffic")
+	flagHostName    = flag.String("hostname", "", "hostname to display for server instance addresses (empty will use OS hostname)")
+	flagStorageDir  = flag.String("storage", "", "where to store server data")
+	flagListenAddr  = flag.String("listen", "127.0.0.1:8080", "address to listen for http traffic")
+	flagMetricsAddr = flag.String("metricslisten", "", "separate address to serve prometheus /metrics on (empty serves it on -listen)")
+	flagScrapeAllow = flag.String("metricsallow", "127.0.0.1,::1", "comma separated addresses or networks allowed to scrape /metrics")
+	flagScrapeToken = flag.String("metricstoken", "", "bearer token that lets any other address scrape /metrics (or set MCMANAGER_METRICS_TOKEN)")
+	flagMetricsPoll = flag.Duration("metricspoll", 30*time.Second, "how often to sample server cpu, memory, disk and tick times")
+	flagPortRange   = flag.String("ports", "25565-25665", "range of game ports for new servers, requested ports must be in it too (rcon uses the game port - 10000)")
+	flagTrashDays   = flag.Int("trashdays", 0, "purge deleted servers after this many days (0 keeps them forever)")
+	flagTrashArch   = flag.Bool("trasharchive", true, "archive deleted servers to storage before purging them")
+	flagAdmins      = flag.String("admins", "", "comma separated player names of manager admins (seeds the admin list in storage when there is none)")
+	flagCreators    = flag.String("creators", "", "comma separated player names allowed to create servers (empty allows everyone)")
+	flagMemBudget   = flag.String("membudget", "", "total memory running servers may reserve, e.g. 24G (empty only checks available host memory)")
+	flagMaxServers  = flag.Int("maxservers", 0, "servers each player may own (0 is unlimited, admins are exempt)")
+	flagMaxMemory   = flag.String("maxmemory", "", "total server memory each player may own, e.g. 16G (empty is unlimited)")
+	flagMaxDisk     = flag.String("maxdisk", "", "total disk space each player's servers may use, e.g. 50G (empty is unlimited)")
+	flagMSClientID  = flag.String("msclientid", "", "azure application (client) id used for microsoft account logins")
+	flagMSLoginURL  = flag.String("msloginurl", auth.Microsoft.LoginURL, "microsoft oauth2 endpoint base url (device code and token)")
+	flagXboxURL     = flag.String("xboxurl", auth.Microsoft.XboxURL, "xbox live user authentication base url")
+	flagXSTSURL     = flag.String("xstsurl", auth.Microsoft.XSTSURL, "xbox live xsts authorization base url")
+	flagMCURL       = flag.String("mcservicesurl", auth.Microsoft.MinecraftURL, "minecraft services base url (login and profile)")
+	flagProviders   = flag.String("authproviders", "microsoft,local", "comma separated login providers to offer: microsoft, local, oidc")
+	flagOIDCIssuer  = flag.String("oidcissuer", "", "openid connect issuer url for single sign-on logins")
+	flagOIDCClient  = flag.String("oidcclientid", "", "openid connect client id")
+	flagOIDCSecret  = flag.String("oidcsecret", "", "openid connect client secret (or set MCMANAGER_OIDC_SECRET)")
+	flagOIDCRedir   = flag.String("oidcredirect", "", "openid connect redirect url, https://<this host>/api/v1/login/oidc/callback")
+	flagOIDCClaim   = flag.String("oidcplayerclaim", "", "id token (or userinfo) claim holding the minecraft player name, must be one users can't edit (empty uses admin-made links)")
+	flagOIDCLabel   = flag.String("oidclabel", auth.OIDC.Label, "login button text for the openid connect provider")
+	flagSetPassword = flag.String("setpassword", "", "create a local account or set its password (read from stdin) and exit")
+	flagLinkPlayer  = flag.String("linkplayer", "", "with -setpassword, link the account to this minecraft player")
+	flagProxies     = flag.String("trustedproxies", "127.0.0.1,::1", "comma separated addresses or networks of reverse proxies whose X-Forwarded-For/-Proto headers are trusted")
+	flagLoginRate   = flag.Int("loginrate", auth.Throttle.PerMinute, "login attempts each client address may make per minute (0 is unlimited)")
+	flagLoginDelay  = flag.Duration("loginbackoff", auth.Throttle.BaseDelay, "first delay after repeated failed logins, doubled on every further failure")
+	flagLockAfter   = flag.Int("loginlockafter", auth.Throttle.LockAfter, "failed logins from one address, or from one address for one username, before locking it out (0 never locks)")
+	flagLockout     = flag.Duration("loginlockout", auth.Throttle.Lockout, "how long a login lockout lasts")
+	flagSessionTTL  = flag.Duration("sessionttl", auth.SessionLifetime, "how long a login stays valid")
+	flagSessionPoll = flag.Duration("sessionpoll", time.Minute, "how often to poll servers for player joins/leaves")
 
 	// Java versions
 	flagJava16 = flag.String("16", "java", "Command to run Java 16")
@@ -39,8 +85,30 @@ func main() {
 	flag.Parse()
 
 	server.Hostname(*flagHostName)
+	status.SetVersion(VERSION)
 	server.Java16 = *flagJava16
 	server.Java8 = *flagJava8
+	server.TrashRetention = time.Duration(*flagTrashDays) * 24 * time.Hour
+	server.TrashArchive = *flagTrashArch
+	auth.Microsoft.ClientID = *flagMSClientID
+	auth.Microsoft.LoginURL = strings.TrimSuffix(*flagMSLoginURL, "/")
+	auth.Microsoft.XboxURL = strings.TrimSuffix(*flagXboxURL, "/")
+	auth.Microsoft.XSTSURL = strings.TrimSuffix(*flagXSTSURL, "/")
+	auth.Microsoft.MinecraftURL = strings.TrimSuffix(*flagMCURL, "/")
+	auth.SessionLifetime = *flagSessionTTL
+	auth.Throttle.PerMinute = *flagLoginRate
+	auth.Throttle.BaseDelay = *flagLoginDelay
+	auth.Throttle.LockAfter = *flagLockAfter
+	auth.Throttle.Lockout = *flagLockout
+	auth.OIDC.Issuer = strings.TrimSuffix(*flagOIDCIssuer, "/")
+	auth.OIDC.ClientID = *flagOIDCClient
+	auth.OIDC.ClientSecret = *flagOIDCSecret
+	if auth.OIDC.ClientSecret == "" {
+		auth.OIDC.ClientSecret = os.Getenv("MCMANAGER_OIDC_SECRET")
+	}
+	auth.OIDC.RedirectURL = *flagOIDCRedir
+	auth.OIDC.PlayerClaim = *flagOIDCClaim
+	auth.OIDC.Label = *flagOIDCLabel
 
 	if *flagStorageDir == "" {
 		fmt.Println("option -storage is required")
@@ -53,15 +121,117 @@ func main() {
 		os.Exit(1)
 	}
 
+	err = proxy.SetTrusted(*flagProxies)
+	if err != nil {
+		fmt.Printf("option -trustedproxies: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *flagScrapeToken == "" {
+		*flagScrapeToken = os.Getenv("MCMANAGER_METRICS_TOKEN")
+	}
+	err = mcmhttp.SetMetricsAccess(*flagScrapeAllow, *flagScrapeToken)
+	if err != nil {
+		fmt.Printf("option -metricsallow: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	err = auth.EnableProviders(*flagProviders)
+	if err != nil {
+		fmt.Printf("option -authproviders: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	for _, name := range strings.Split(*flagCreators, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			server.CreatePolicy.Creators = append(server.CreatePolicy.Creators, name)
+		}
+	}
+	server.CreatePolicy.MaxServers = *flagMaxServers
+	if *flagMaxMemory != "" {
+		server.CreatePolicy.MaxMemory, err = server.ParseMemory(*flagMaxMemory)
+		if err != nil {
+			fmt.Printf("option -maxmemory: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if *flagMemBudget != "" {
+		server.MemoryBudget, err = server.ParseMemory(*flagMemBudget)
+		if err != nil {
+			fmt.Printf("option -membudget: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if *flagMaxDisk != "" {
+		server.CreatePolicy.MaxDisk, err = server.ParseMemory(*flagMaxDisk)
+		if err != nil {
+			fmt.Printf("option -maxdisk: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	err = storage.Prepare(*flagStorageDir)
 	if err != nil {
 		fmt.Printf("ERROR making storage dirs: %s", err.Error())
 		os.Exit(1)
 	}
 
-	err = auth.LoadTokenCache()
+	err = ports.SetRange(*flagPortRange)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	err = ports.Load()
+	if err != nil {
+		fmt.Printf("ERROR loading port assignments: %s\n", err.Error())
+	}
+
+	err = jobs.Load()
 	if err != nil {
-		fmt.Printf("ERROR loading token cache: %s\n", err.Error())
+		fmt.Printf("ERROR loading jobs: %s\n", err.Error())
+	}
+
+	err = auth.LoadSessions()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR loading sessions: %s\n", err.Error())
+	}
+
+	err = auth.LoadAPITokens()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR loading api tokens: %s\n", err.Error())
+	}
+
+	// older versions kept Mojang access tokens here, sessions replaced them
+	os.Remove(filepath.Join(storage.STORAGEDIR, "token_cache.json"))
+
+	err = auth.LoadPlayers()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR loading players: %s\n", err.Error())
+	}
+
+	err = auth.LoadAdmins(*flagAdmins)
+	if err != nil {
+		fmt.Printf("ERROR loading admins: %s\n", err.Error())
+	}
+
+	err = auth.LoadAccounts()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR loading accounts: %s\n", err.Error())
+	}
+
+	err = auth.LoadLinks()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR loading identity links: %s\n", err.Error())
+	}
+
+	if *flagSetPassword != "" {
+		err = setPassword(*flagSetPassword, *flagLinkPlayer)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
 	go func() {
@@ -69,16 +239,19 @@ func main() {
 		for {
 
 			err = vanilla.RefreshReleases()
+			status.RecordRefresh("vanilla releases", err)
 			if err != nil {
 				fmt.Println(err.Error())
 			}
 
 			err = paper.RefreshReleases()
+			status.RecordRefresh("paper releases", err)
 			if err != nil {
 				fmt.Println(err.Error())
 			}
 
 			err = vanilla.RefreshNews(10)
+			status.RecordRefresh("news", err)
 			if err != nil {
 				fmt.Println(err.Error())
 			}
@@ -98,7 +271,8 @@ func main() {
 	go func() {
 		for sig := range c {
 			fmt.Printf("Received %s... instructing running instances to save\n", sig.String())
-			for _, s := range server.Servers {
+			sessions.Flush()
+			for _, s := range server.Servers.All() {
 				if s.IsRunning() {
 					err := s.Save()
 					if err != nil {
@@ -110,14 +284,46 @@ func main() {
 		}
 	}()
 
-	for _, instance := range server.Servers {
-		if instance.AutoStart {
-			instance.Start()
+	for _, instance := range server.Servers.All() {
+		if instance.AutoStart && !instance.Deleted {
+			if err := server.Servers.Do(instance.UUID, (*server.Server).Start); err != nil {
+				fmt.Printf("ERROR auto starting %s: %s\n", instance.Name, err.Error())
+			}
 		}
 	}
 
-	err = mcmhttp.Listen(APPTITLE, *flagListenAddr, &webfiles, *flagHostName)
+	go func() {
+		for {
+			server.PurgeExpired()
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	go server.WatchProperties(time.Minute)
+	go sessions.Track(*flagSessionPoll)
+	go metrics.Collect(*flagMetricsPoll)
+
+	err = mcmhttp.Listen(APPTITLE, *flagListenAddr, &webfiles, *flagHostName, *flagMetricsAddr)
 	if err != nil {
 		log.Printf("HTTP thread exited with error: %s", err.Error())
 	}
 }
+
+// setPassword creates or updates a local account from the command line, the
+// password is read from stdin so it doesn't show up in the process list
+func setPassword(username, player string) error {
+	fmt.Printf("Password for %s: ", username)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+
+	err = auth.SetPassword(username, strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return err
+	}
+	if player != "" {
+		return auth.LinkIdentity("local:"+username, player)
+	}
+	return nil
+}
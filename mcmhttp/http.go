@@ -13,6 +13,7 @@ import (
 	"github.com/jlmeeker/mcmanager/auth"
 	"github.com/jlmeeker/mcmanager/paper"
 	"github.com/jlmeeker/mcmanager/releases"
+	"github.com/jlmeeker/mcmanager/status"
 	"github.com/jlmeeker/mcmanager/vanilla"
 )
 
@@ -23,7 +24,9 @@ var APPTITLE string
 var HOSTNAME string
 
 // Listen starts the Gin web server
-func Listen(appTitle, addr string, webfiles *fs.FS, hostname string) error {
+// /metrics is served on metricsAddr when set, otherwise alongside the web UI,
+// either way only to the scrapers allowed by SetMetricsAccess
+func Listen(appTitle, addr string, webfiles *fs.FS, hostname, metricsAddr string) error {
 	APPTITLE = appTitle
 	HOSTNAME = hostname
 
@@ -37,7 +40,9 @@ func Listen(appTitle, addr string, webfiles *fs.FS, hostname string) error {
 	}
 
 	//webfiles := getFileSystem()
-	t, err := template.ParseFS(*webfiles, "*.html")
+	t, err := template.New("index.html").Funcs(template.FuncMap{
+		"bytes": formatBytes,
+	}).ParseFS(*webfiles, "*.html")
 	if err != nil {
 		panic(err)
 	}
@@ -48,7 +53,8 @@ func Listen(appTitle, addr string, webfiles *fs.FS, hostname string) error {
 	}
 
 	router := gin.Default()
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	router.Use(requestCounter())
+	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/api/v1/events"})))
 	router.SetHTMLTemplate(t)
 	router.StaticFS("/img", http.FS(staticfiles))
 	router.StaticFS("/js", http.FS(staticfiles))
@@ -56,6 +62,17 @@ func Listen(appTitle, addr string, webfiles *fs.FS, hostname string) error {
 	router.GET("/", viewHandler)
 	router.GET("/view/:page", viewHandler)
 
+	if metricsAddr == "" {
+		router.GET("/metrics", metricsAccess(), prometheusHandler)
+	} else {
+		go func() {
+			err := listenMetrics(metricsAddr)
+			if err != nil {
+				fmt.Printf("metrics listener exited with error: %s\n", err.Error())
+			}
+		}()
+	}
+
 	v1 := router.Group("/api/v1")
 	apiv1.V1Routes(v1)
 
@@ -65,6 +82,7 @@ func Listen(appTitle, addr string, webfiles *fs.FS, hostname string) error {
 // PageData defines data that is passed to HTML templates
 type PageData struct {
 	Authenticated bool
+	IsAdmin       bool
 	PlayerName    string
 	AppTitle      string
 	Hostname      string
@@ -75,9 +93,7 @@ type PageData struct {
 		Paper   releases.VersionFile
 	}
 	//Servers map[string]server.WebView
-	Status struct {
-		Uptime string
-	}
+	Status status.Report
 }
 
 func notFoundHandler(c *gin.Context) {
@@ -99,14 +115,16 @@ func viewHandler(c *gin.Context) {
 
 	token, _ := c.Cookie("token")
 	playerName, _ := c.Cookie("player")
-	if auth.VerifyToken(playerName, token) {
+	if auth.VerifySession(playerName, token) {
+		apiv1.CSRFCookie(c, token)
 		pd.Authenticated = true
+		pd.IsAdmin = auth.IsAdmin(playerName)
 		pd.PlayerName = playerName
 	} else {
-		fmt.Printf("unauthenticated request: %s\n%s\n", playerName, token)
+		fmt.Printf("unauthenticated request: %s\n", playerName)
 	}
 
-	status := http.StatusOK
+	code := http.StatusOK
 	page := c.Param("page")
 	switch page {
 	case "", "home":
@@ -116,12 +134,38 @@ func viewHandler(c *gin.Context) {
 		//pd.Servers = server.ServersWebView(playerName)
 	case "releases":
 		pd.Page = "releases"
+	case "trash":
+		pd.Page = "trash"
+	case "admin":
+		pd.Page = "admin"
 	case "status":
 		pd.Page = "status"
+		pd.Status = status.Get(pd.PlayerName)
 	default:
 		pd.Page = "notfound"
-		status = http.StatusNotFound
+		code = http.StatusNotFound
 	}
 
-	c.HTML(status, "index.html", pd)
+	c.HTML(code, "index.html", pd)
+}
+
+// formatBytes is a template helper to display sizes in human readable units
+func formatBytes(val interface{}) string {
+	var size float64
+	switch v := val.(type) {
+	case int64:
+		size = float64(v)
+	case uint64:
+		size = float64(v)
+	case int:
+		size = float64(v)
+	}
+
+	var units = []string{"B", "KB", "MB", "GB", "TB"}
+	var i int
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
 }
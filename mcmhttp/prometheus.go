@@ -0,0 +1,147 @@
+package mcmhttp
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jlmeeker/mcmanager/metrics"
+	"github.com/jlmeeker/mcmanager/proxy"
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/stats"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// metricsAllow are the client networks that may scrape /metrics, metricsToken
+// (when set) lets any other client in as a bearer token
+var (
+	metricsAllow []*net.IPNet
+	metricsToken string
+)
+
+// SetMetricsAccess sets who may scrape /metrics: a comma separated list of
+// addresses or networks, and an optional bearer token
+func SetMetricsAccess(allow, token string) error {
+	networks, err := proxy.ParseNetworks(allow)
+	if err != nil {
+		return err
+	}
+	metricsAllow = networks
+	metricsToken = token
+	return nil
+}
+
+// metricsAccess refuses scrapers that aren't allowed by address or token
+func metricsAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if proxy.InNetworks(proxy.ClientIP(c.Request), metricsAllow) {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Authorization")
+		if metricsToken != "" && subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+metricsToken)) == 1 {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+// requestCounter counts every request by route and status code
+func requestCounter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "notfound"
+		}
+		stats.Inc(stats.HTTPRequests, "route", route, "status", strconv.Itoa(c.Writer.Status()))
+	}
+}
+
+// prometheusHandler writes the manager counters and per-server gauges in the Prometheus text format
+func prometheusHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+	w := c.Writer
+
+	stats.WriteCounters(w)
+
+	type gauge struct {
+		name  string
+		help  string
+		value func(s *server.Server) (float64, bool)
+	}
+
+	var gauges = []gauge{
+		{"mcmanager_server_running", "Whether the server is running (1) or not (0).", func(s *server.Server) (float64, bool) {
+			if s.IsRunning() {
+				return 1, true
+			}
+			return 0, true
+		}},
+		{"mcmanager_server_players_online", "Players currently online.", func(s *server.Server) (float64, bool) {
+			st, err := s.Ping()
+			return float64(st.Players.Online), err == nil
+		}},
+		{"mcmanager_server_memory_bytes", "Resident memory of the server process.", func(s *server.Server) (float64, bool) {
+			smpl, ok := metrics.Latest(s.UUID)
+			return float64(smpl.RSS), ok
+		}},
+		{"mcmanager_server_uptime_seconds", "Seconds since the server process started.", func(s *server.Server) (float64, bool) {
+			smpl, ok := metrics.Latest(s.UUID)
+			return float64(smpl.Uptime), ok
+		}},
+		{"mcmanager_server_tps", "Ticks per second, missing when the server can't report it.", func(s *server.Server) (float64, bool) {
+			smpl, ok := metrics.Latest(s.UUID)
+			if !ok || smpl.TPS == nil {
+				return 0, false
+			}
+			return *smpl.TPS, true
+		}},
+		{"mcmanager_server_mspt", "Milliseconds per tick (paper only).", func(s *server.Server) (float64, bool) {
+			smpl, ok := metrics.Latest(s.UUID)
+			if !ok || smpl.MSPT == nil {
+				return 0, false
+			}
+			return *smpl.MSPT, true
+		}},
+		{"mcmanager_server_last_backup_age_seconds", "Seconds since the last backup commit.", func(s *server.Server) (float64, bool) {
+			t, err := storage.LastBackup(s.UUID)
+			return time.Since(t).Seconds(), err == nil
+		}},
+		{"mcmanager_server_crashes", "Crash reports written by the server.", func(s *server.Server) (float64, bool) {
+			return float64(s.CrashCount()), true
+		}},
+	}
+
+	var servers []*server.Server
+	for _, s := range server.Servers.All() {
+		if !s.Deleted {
+			servers = append(servers, s)
+		}
+	}
+
+	for _, g := range gauges {
+		stats.WriteGaugeHeader(w, g.name, g.help)
+		for _, s := range servers {
+			if val, ok := g.value(s); ok {
+				stats.WriteGauge(w, g.name, val, "server", s.UUID, "name", s.Name)
+			}
+		}
+	}
+}
+
+// listenMetrics serves /metrics on its own address, away from the web UI
+func listenMetrics(addr string) error {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/metrics", metricsAccess(), prometheusHandler)
+	return router.Run(addr)
+}
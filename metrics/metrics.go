@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"log"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/rconparse"
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// MaxSamples is how many samples are kept per server
+var MaxSamples = 360
+
+// Sample is a single point in a server's metrics time series
+// TPS comes from the paper/spigot "tps" command, or on other servers from how far
+// the game time moved since the previous sample. MSPT needs paper's "mspt" command.
+// Either is null when the server can't report it.
+type Sample struct {
+	Time      time.Time `json:"time"`
+	CPU       float64   `json:"cpu"` // percent of one core
+	RSS       int64     `json:"rss"` // bytes
+	DiskBytes int64     `json:"disk"`
+	Uptime    int64     `json:"uptime"` // seconds
+	TPS       *float64  `json:"tps"`
+	MSPT      *float64  `json:"mspt"`
+
+	gameTime int64 // ticks, from "time query gametime"
+}
+
+type series struct {
+	samples      []Sample
+	lastTick     uint64
+	lastTime     time.Time
+	lastGameTime int64
+}
+
+var (
+	data = make(map[string]*series)
+	mu   sync.Mutex
+)
+
+// Collect samples all running servers on the given interval (expected to be run as a goroutine)
+func Collect(interval time.Duration) {
+	for {
+		for _, s := range server.Servers.All() {
+			id := s.UUID
+			if s.Deleted || !s.IsRunning() {
+				continue
+			}
+
+			smpl, ticks, err := sample(s)
+			if err != nil {
+				log.Printf("metrics: unable to sample %s: %s", id, err.Error())
+				continue
+			}
+			record(id, smpl, ticks)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// sample reads the process, disk and tick stats of a server
+func sample(s *server.Server) (Sample, uint64, error) {
+	var smpl = Sample{Time: time.Now()}
+
+	smpl.DiskBytes = storage.DirSize(filepath.Join(s.ServerDir(), "world"))
+	smpl.DiskBytes += storage.DirSize(filepath.Join(s.ServerDir(), "world_nether"))
+	smpl.DiskBytes += storage.DirSize(filepath.Join(s.ServerDir(), "world_the_end"))
+
+	pid, err := findPID(s.ServerDir())
+	if err != nil {
+		return smpl, 0, err
+	}
+
+	ps, err := readProcStat(pid)
+	if err != nil {
+		return smpl, 0, err
+	}
+	smpl.RSS = ps.rss
+
+	if up, err := uptimeSeconds(); err == nil {
+		smpl.Uptime = int64(up - float64(ps.startTime)/clockTicks)
+	}
+
+	switch s.Flavor {
+	case "paper", "spigot":
+		if reply, err := s.Rcon("tps"); err == nil {
+			if tps, err := rconparse.TPS(reply); err == nil {
+				smpl.TPS = &tps[0]
+			}
+		}
+	}
+
+	// vanilla has no tps command, record() works it out from the game time
+	if smpl.TPS == nil {
+		if reply, err := s.Rcon("time query gametime"); err == nil {
+			smpl.gameTime, _ = rconparse.Time(reply)
+		}
+	}
+
+	if s.Flavor == "paper" {
+		if reply, err := s.Rcon("mspt"); err == nil {
+			if mspt, err := rconparse.MSPT(reply); err == nil {
+				smpl.MSPT = &mspt[0]
+			}
+		}
+	}
+
+	return smpl, ps.ticks, nil
+}
+
+// record appends a sample to a server's series, calculating cpu from the previous sample
+func record(serverID string, smpl Sample, ticks uint64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sr, ok := data[serverID]
+	if !ok {
+		sr = &series{}
+		data[serverID] = sr
+	}
+
+	if !sr.lastTime.IsZero() {
+		elapsed := smpl.Time.Sub(sr.lastTime).Seconds()
+		if elapsed > 0 && ticks >= sr.lastTick {
+			smpl.CPU = float64(ticks-sr.lastTick) / clockTicks / elapsed * 100
+		}
+		if elapsed > 0 && smpl.gameTime > 0 && sr.lastGameTime > 0 && smpl.gameTime >= sr.lastGameTime {
+			// catching up after a lag spike runs ticks back to back, never report more than 20
+			tps := math.Min(float64(smpl.gameTime-sr.lastGameTime)/elapsed, 20)
+			smpl.TPS = &tps
+		}
+	}
+	sr.lastTick = ticks
+	sr.lastTime = smpl.Time
+	sr.lastGameTime = smpl.gameTime
+
+	sr.samples = append(sr.samples, smpl)
+	if len(sr.samples) > MaxSamples {
+		sr.samples = sr.samples[len(sr.samples)-MaxSamples:]
+	}
+}
+
+// Series returns a copy of the collected samples for a server, oldest first
+func Series(serverID string) []Sample {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var result = []Sample{}
+	if sr, ok := data[serverID]; ok {
+		result = append(result, sr.samples...)
+	}
+	return result
+}
+
+// Latest returns the most recent sample for a server
+func Latest(serverID string) (Sample, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sr, ok := data[serverID]
+	if !ok || len(sr.samples) == 0 {
+		return Sample{}, false
+	}
+	return sr.samples[len(sr.samples)-1], true
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux platform we care about
+const clockTicks = 100
+
+// procStat is the subset of /proc/<pid>/stat we use
+type procStat struct {
+	ticks     uint64 // utime + stime
+	startTime uint64 // clock ticks after boot
+	rss       int64  // bytes
+}
+
+// findPID returns the pid of the process whose working directory is dir
+// (servers are started with cmd.Dir set to their server dir, so this finds the JVM)
+func findPID(dir string) (int, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		cwd, err := os.Readlink(filepath.Join("/proc", entry.Name(), "cwd"))
+		if err != nil || cwd != dir {
+			continue
+		}
+
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err == nil && strings.Contains(string(cmdline), "java") {
+			return pid, nil
+		}
+	}
+
+	return 0, errors.New("process not found")
+}
+
+// readProcStat reads the cpu ticks, start time and resident memory of a process
+func readProcStat(pid int) (procStat, error) {
+	var ps procStat
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return ps, err
+	}
+
+	// the command name (field 2) may contain spaces, so split after its closing paren
+	stat := string(b)
+	ndx := strings.LastIndex(stat, ")")
+	if ndx < 0 {
+		return ps, errors.New("malformed stat file")
+	}
+	fields := strings.Fields(stat[ndx+1:])
+	if len(fields) < 22 {
+		return ps, errors.New("malformed stat file")
+	}
+
+	// fields[0] is field 3 (state) in proc(5)
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	ps.ticks = utime + stime
+	ps.startTime, _ = strconv.ParseUint(fields[19], 10, 64)
+	rssPages, _ := strconv.ParseInt(fields[21], 10, 64)
+	ps.rss = rssPages * int64(os.Getpagesize())
+	return ps, nil
+}
+
+// uptimeSeconds returns the system uptime from /proc/uptime
+func uptimeSeconds() (float64, error) {
+	b, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, errors.New("malformed uptime file")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
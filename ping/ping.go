@@ -0,0 +1,343 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// DefaultTimeout is used when a zero timeout is passed in
+var DefaultTimeout = 3 * time.Second
+
+// protocolVersion sent in the handshake, -1 asks the server to reply with its own
+const protocolVersion = -1
+
+// Player is an entry in the status reply player sample
+type Player struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// Status is the result of a Server List Ping
+type Status struct {
+	Version struct {
+		Name     string `json:"name"`
+		Protocol int    `json:"protocol"`
+	} `json:"version"`
+	Players struct {
+		Max    int      `json:"max"`
+		Online int      `json:"online"`
+		Sample []Player `json:"sample"`
+	} `json:"players"`
+	Description Chat          `json:"description"`
+	Favicon     string        `json:"favicon"`
+	Latency     time.Duration `json:"-"`
+}
+
+// MOTD returns the plain text message of the day
+func (st Status) MOTD() string {
+	return st.Description.String()
+}
+
+// SampleNames returns the names of the players in the status sample
+func (st Status) SampleNames() []string {
+	var names []string
+	for _, p := range st.Players.Sample {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// Chat is a minecraft chat component, sent either as a plain string or as an object
+type Chat struct {
+	Text  string `json:"text"`
+	Extra []Chat `json:"extra"`
+}
+
+// UnmarshalJSON accepts both the string and object forms of a chat component
+func (c *Chat) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &c.Text)
+	}
+
+	type chat Chat
+	var raw chat
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+	*c = Chat(raw)
+	return nil
+}
+
+// String flattens the component (and its extras) into plain text, minus any color codes
+func (c Chat) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Text)
+	for _, e := range c.Extra {
+		sb.WriteString(e.String())
+	}
+	return StripCodes(sb.String())
+}
+
+// StripCodes removes legacy § formatting codes from a string
+func StripCodes(s string) string {
+	var sb strings.Builder
+	var skip bool
+	for _, r := range s {
+		if skip {
+			skip = false
+			continue
+		}
+		if r == '§' {
+			skip = true
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Query performs a modern (1.7+) Server List Ping: handshake, status request and ping
+func Query(host string, port int, timeout time.Duration) (Status, error) {
+	var st Status
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return st, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// handshake (0x00) with next state 1 (status), then the status request (0x00)
+	var hs bytes.Buffer
+	writeVarInt(&hs, 0x00)
+	writeVarInt(&hs, protocolVersion)
+	writeString(&hs, host)
+	binary.Write(&hs, binary.BigEndian, uint16(port))
+	writeVarInt(&hs, 1)
+
+	if err = writePacket(conn, hs.Bytes()); err != nil {
+		return st, err
+	}
+	if err = writePacket(conn, []byte{0x00}); err != nil {
+		return st, err
+	}
+
+	rd := bufio.NewReader(conn)
+	payload, err := readPacket(rd)
+	if err != nil {
+		return st, err
+	}
+
+	pr := bytes.NewReader(payload)
+	id, err := readVarInt(pr)
+	if err != nil {
+		return st, err
+	}
+	if id != 0x00 {
+		return st, fmt.Errorf("unexpected status packet id %#x", id)
+	}
+
+	body, err := readString(pr)
+	if err != nil {
+		return st, err
+	}
+
+	err = json.Unmarshal([]byte(body), &st)
+	if err != nil {
+		return st, err
+	}
+
+	// ping (0x01) with the current time, server echoes it back as a pong
+	var pb bytes.Buffer
+	writeVarInt(&pb, 0x01)
+	binary.Write(&pb, binary.BigEndian, time.Now().UnixNano())
+
+	start := time.Now()
+	if err = writePacket(conn, pb.Bytes()); err != nil {
+		return st, err
+	}
+	if _, err = readPacket(rd); err != nil {
+		// some servers close the connection instead of replying, the status is still good
+		return st, nil
+	}
+	st.Latency = time.Since(start)
+
+	return st, nil
+}
+
+// Legacy performs the 1.6 style ping (0xFE 0x01 + MC|PingHost plugin message)
+// Only the version, MOTD and player counts are available from this reply.
+func Legacy(host string, port int, timeout time.Duration) (Status, error) {
+	var st Status
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return st, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var req bytes.Buffer
+	req.Write([]byte{0xFE, 0x01, 0xFA})
+	writeLegacyString(&req, "MC|PingHost")
+	var data bytes.Buffer
+	data.WriteByte(74) // last protocol version to use this ping
+	writeLegacyString(&data, host)
+	binary.Write(&data, binary.BigEndian, int32(port))
+	binary.Write(&req, binary.BigEndian, int16(data.Len()))
+	req.Write(data.Bytes())
+
+	start := time.Now()
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		return st, err
+	}
+
+	var hdr [3]byte
+	if _, err = io.ReadFull(conn, hdr[:]); err != nil {
+		return st, err
+	}
+	st.Latency = time.Since(start)
+
+	if hdr[0] != 0xFF {
+		return st, fmt.Errorf("unexpected legacy ping packet id %#x", hdr[0])
+	}
+
+	length := binary.BigEndian.Uint16(hdr[1:])
+	raw := make([]byte, int(length)*2)
+	if _, err = io.ReadFull(conn, raw); err != nil {
+		return st, err
+	}
+
+	return parseLegacy(raw, st)
+}
+
+// parseLegacy decodes the UTF-16BE kick message of a legacy ping reply
+// §1\x00<protocol>\x00<version>\x00<motd>\x00<online>\x00<max>
+func parseLegacy(raw []byte, st Status) (Status, error) {
+	u := make([]uint16, len(raw)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(raw[i*2:])
+	}
+
+	fields := strings.Split(string(utf16.Decode(u)), "\x00")
+	if len(fields) != 6 || fields[0] != "§1" {
+		return st, errors.New("malformed legacy ping reply")
+	}
+
+	st.Version.Protocol, _ = strconv.Atoi(fields[1])
+	st.Version.Name = fields[2]
+	st.Description.Text = fields[3]
+	st.Players.Online, _ = strconv.Atoi(fields[4])
+	st.Players.Max, _ = strconv.Atoi(fields[5])
+	return st, nil
+}
+
+// Ping tries the modern protocol first, falling back to the legacy ping
+func Ping(host string, port int, timeout time.Duration) (Status, error) {
+	st, err := Query(host, port, timeout)
+	if err == nil {
+		return st, nil
+	}
+
+	lst, lerr := Legacy(host, port, timeout)
+	if lerr != nil {
+		return st, err
+	}
+	return lst, nil
+}
+
+func writePacket(w io.Writer, data []byte) error {
+	var b bytes.Buffer
+	writeVarInt(&b, int32(len(data)))
+	b.Write(data)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func readPacket(r io.ByteReader) ([]byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > 1<<21 {
+		return nil, fmt.Errorf("invalid packet length %d", length)
+	}
+
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i], err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func writeVarInt(b *bytes.Buffer, v int32) {
+	uv := uint32(v)
+	for {
+		if uv&^0x7F == 0 {
+			b.WriteByte(byte(uv))
+			return
+		}
+		b.WriteByte(byte(uv&0x7F | 0x80))
+		uv >>= 7
+	}
+}
+
+func readVarInt(r io.ByteReader) (int32, error) {
+	var result uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint32(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(result), nil
+		}
+	}
+	return 0, errors.New("varint too big")
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	writeVarInt(b, int32(len(s)))
+	b.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", fmt.Errorf("invalid string length %d", length)
+	}
+
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func writeLegacyString(b *bytes.Buffer, s string) {
+	u := utf16.Encode([]rune(s))
+	binary.Write(b, binary.BigEndian, int16(len(u)))
+	binary.Write(b, binary.BigEndian, u)
+}
@@ -0,0 +1,242 @@
+package ports
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// RconOffset is how far below the game port the rcon port lives
+const RconOffset = 10000
+
+// ErrUnavailable is wrapped by the errors of ports that can't be allocated
+var ErrUnavailable = errors.New("ports unavailable")
+
+// Allocation range for game ports (inclusive)
+var (
+	MinPort = 25565
+	MaxPort = 25665
+)
+
+// Assignment is the set of ports a server uses
+// query shares the game port number (query is UDP, the game is TCP)
+type Assignment struct {
+	Game  int `json:"game"`
+	Query int `json:"query"`
+	Rcon  int `json:"rcon"`
+}
+
+// NewAssignment builds the assignment for a game port
+func NewAssignment(game int) Assignment {
+	return Assignment{
+		Game:  game,
+		Query: game,
+		Rcon:  game - RconOffset,
+	}
+}
+
+// all returns every distinct port in the assignment
+func (a Assignment) all() []int {
+	var result = []int{a.Game}
+	if a.Query != a.Game {
+		result = append(result, a.Query)
+	}
+	return append(result, a.Rcon)
+}
+
+var (
+	assignments = make(map[string]Assignment)
+	mu          sync.Mutex
+)
+
+// SetRange parses a "min-max" game port range
+func SetRange(spec string) error {
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid port range %q, expected min-max", spec)
+	}
+
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return err
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return err
+	}
+
+	if min > max || min-RconOffset < 1024 || max > 65535 {
+		return fmt.Errorf("invalid port range %q (rcon ports are %d below the game port)", spec, RconOffset)
+	}
+
+	MinPort = min
+	MaxPort = max
+	return nil
+}
+
+// Load reads the persisted assignments
+func Load() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	b, err := os.ReadFile(registryFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &assignments)
+}
+
+// Get returns a server's assignment
+func Get(serverID string) (Assignment, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	a, ok := assignments[serverID]
+	return a, ok
+}
+
+// Allocate assigns ports to a server. A requested game port must be in the MinPort-MaxPort
+// range, 0 scans the range for a free one.
+func Allocate(serverID string, requested int) (Assignment, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if serverID == "" {
+		return Assignment{}, errors.New("cannot allocate ports without a server ID")
+	}
+
+	if requested != 0 {
+		a := NewAssignment(requested)
+		if requested < MinPort || requested > MaxPort {
+			return a, fmt.Errorf("%w: port %d is outside the allowed range %d-%d", ErrUnavailable, requested, MinPort, MaxPort)
+		}
+		if err := checkFree(serverID, a); err != nil {
+			return a, fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+		}
+		return a, assign(serverID, a)
+	}
+
+	for port := MinPort; port <= MaxPort; port++ {
+		a := NewAssignment(port)
+		if checkFree(serverID, a) == nil {
+			return a, assign(serverID, a)
+		}
+	}
+
+	return Assignment{}, fmt.Errorf("%w: no free ports between %d and %d", ErrUnavailable, MinPort, MaxPort)
+}
+
+// Release frees a server's ports
+func Release(serverID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := assignments[serverID]; !ok {
+		return nil
+	}
+	delete(assignments, serverID)
+	return save()
+}
+
+// Sync registers the ports servers are actually configured with and reports collisions
+// between servers. Servers missing from current are released.
+func Sync(current map[string]Assignment) []error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var errs []error
+	var owners = make(map[int]string)
+
+	var ids []string
+	for id := range current {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		for _, port := range current[id].all() {
+			if other, ok := owners[port]; ok && other != id {
+				errs = append(errs, fmt.Errorf("port %d is used by both %s and %s", port, other, id))
+				continue
+			}
+			owners[port] = id
+		}
+	}
+
+	assignments = current
+	if err := save(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// checkFree verifies no other server has the ports and that they can be bound (caller must hold mu)
+func checkFree(serverID string, a Assignment) error {
+	for id, other := range assignments {
+		if id == serverID {
+			continue
+		}
+		for _, p := range a.all() {
+			for _, op := range other.all() {
+				if p == op {
+					return fmt.Errorf("port %d is assigned to %s", p, id)
+				}
+			}
+		}
+	}
+
+	for _, p := range a.all() {
+		if err := bindable(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindable checks a port is free for both TCP and UDP
+func bindable(port int) error {
+	addr := ":" + strconv.Itoa(port)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("tcp port %d is in use", port)
+	}
+	l.Close()
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("udp port %d is in use", port)
+	}
+	pc.Close()
+	return nil
+}
+
+// assign records and persists an assignment (caller must hold mu)
+func assign(serverID string, a Assignment) error {
+	assignments[serverID] = a
+	return save()
+}
+
+// save persists the assignments (caller must hold mu)
+func save() error {
+	b, err := json.MarshalIndent(assignments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(registryFile(), b, storage.DEFAULTFILEPERM)
+}
+
+func registryFile() string {
+	return filepath.Join(storage.STORAGEDIR, "ports.json")
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trusted are the networks of reverse proxies whose X-Forwarded-* headers are believed,
+// requests from anywhere else are taken at face value
+var trusted []*net.IPNet
+
+// SetTrusted parses a comma separated list of proxy addresses or CIDR networks
+func SetTrusted(list string) error {
+	networks, err := ParseNetworks(list)
+	if err != nil {
+		return err
+	}
+	trusted = networks
+	return nil
+}
+
+// ParseNetworks parses a comma separated list of addresses or CIDR networks
+func ParseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if strings.Contains(item, ":") {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or network %q", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// InNetworks returns if an address belongs to one of the networks
+func InNetworks(addr string, networks []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrusted returns if an address belongs to a trusted proxy
+func isTrusted(addr string) bool {
+	return InNetworks(addr, trusted)
+}
+
+// remoteIP is the address the request came from directly
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the address of the client. Behind trusted proxies it is the
+// right-most X-Forwarded-For address that isn't a trusted proxy itself, so a
+// client can't pick its own address by sending the header.
+func ClientIP(r *http.Request) string {
+	var ip = remoteIP(r)
+	if !isTrusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// IsHTTPS returns if the client connected over https, directly or to a trusted proxy
+func IsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !isTrusted(remoteIP(r)) {
+		return false
+	}
+	proto := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
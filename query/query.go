@@ -0,0 +1,276 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is used when a zero timeout is passed in
+var DefaultTimeout = 3 * time.Second
+
+// packet types
+const (
+	typeStat      byte = 0x00
+	typeHandshake byte = 0x09
+)
+
+var magic = []byte{0xFE, 0xFD}
+
+// BasicStat is the reply to a basic stat request
+type BasicStat struct {
+	MOTD       string `json:"motd"`
+	GameType   string `json:"gametype"`
+	Map        string `json:"map"`
+	NumPlayers int    `json:"numplayers"`
+	MaxPlayers int    `json:"maxplayers"`
+	HostPort   int    `json:"hostport"`
+	HostIP     string `json:"hostip"`
+}
+
+// FullStat is the reply to a full stat request
+type FullStat struct {
+	MOTD       string   `json:"motd"`
+	GameType   string   `json:"gametype"`
+	GameID     string   `json:"gameid"`
+	Version    string   `json:"version"`
+	Software   string   `json:"software"`
+	Plugins    []string `json:"plugins"`
+	Map        string   `json:"map"`
+	NumPlayers int      `json:"numplayers"`
+	MaxPlayers int      `json:"maxplayers"`
+	HostPort   int      `json:"hostport"`
+	HostIP     string   `json:"hostip"`
+	Players    []string `json:"players"`
+}
+
+// Client is a connection to a server's query port
+type Client struct {
+	conn    net.Conn
+	session int32
+	token   int32
+	timeout time.Duration
+}
+
+// Dial opens a (UDP) query connection and performs the handshake
+func Dial(host string, port int, timeout time.Duration) (*Client, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		session: rand.Int31() & 0x0F0F0F0F,
+		timeout: timeout,
+	}
+
+	err = c.handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the underlying connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// handshake requests a challenge token, which is needed for all stat requests
+func (c *Client) handshake() error {
+	reply, err := c.send(typeHandshake, nil)
+	if err != nil {
+		return err
+	}
+
+	tok, err := strconv.ParseInt(strings.TrimRight(string(reply), "\x00"), 10, 32)
+	if err != nil {
+		return fmt.Errorf("bad challenge token: %s", err.Error())
+	}
+	c.token = int32(tok)
+	return nil
+}
+
+// Basic requests the basic stat
+func (c *Client) Basic() (BasicStat, error) {
+	var bs BasicStat
+	var payload = make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(c.token))
+
+	reply, err := c.send(typeStat, payload)
+	if err != nil {
+		return bs, err
+	}
+
+	rd := bufio.NewReader(bytes.NewReader(reply))
+	var fields = make([]string, 5)
+	for i := range fields {
+		fields[i], err = readString(rd)
+		if err != nil {
+			return bs, err
+		}
+	}
+
+	var port uint16
+	err = binary.Read(rd, binary.LittleEndian, &port)
+	if err != nil {
+		return bs, err
+	}
+
+	bs.MOTD = fields[0]
+	bs.GameType = fields[1]
+	bs.Map = fields[2]
+	bs.NumPlayers, _ = strconv.Atoi(fields[3])
+	bs.MaxPlayers, _ = strconv.Atoi(fields[4])
+	bs.HostPort = int(port)
+	bs.HostIP, err = readString(rd)
+	return bs, err
+}
+
+// Full requests the full stat, which includes plugins and the complete player list
+func (c *Client) Full() (FullStat, error) {
+	var fs FullStat
+	var payload = make([]byte, 8) // token + 4 bytes of padding
+	binary.BigEndian.PutUint32(payload, uint32(c.token))
+
+	reply, err := c.send(typeStat, payload)
+	if err != nil {
+		return fs, err
+	}
+
+	// 11 bytes of padding: "splitnum\x00\x80\x00"
+	if len(reply) < 11 {
+		return fs, errors.New("full stat reply too short")
+	}
+	rd := bufio.NewReader(bytes.NewReader(reply[11:]))
+
+	var kv = make(map[string]string)
+	for {
+		key, err := readString(rd)
+		if err != nil {
+			return fs, err
+		}
+		if key == "" {
+			break
+		}
+		kv[key], err = readString(rd)
+		if err != nil {
+			return fs, err
+		}
+	}
+
+	// 10 bytes of padding: "\x01player_\x00\x00"
+	if _, err = rd.Discard(10); err != nil {
+		return fs, err
+	}
+
+	for {
+		name, err := readString(rd)
+		if err != nil || name == "" {
+			break
+		}
+		fs.Players = append(fs.Players, name)
+	}
+
+	fs.MOTD = kv["hostname"]
+	fs.GameType = kv["gametype"]
+	fs.GameID = kv["game_id"]
+	fs.Version = kv["version"]
+	fs.Map = kv["map"]
+	fs.NumPlayers, _ = strconv.Atoi(kv["numplayers"])
+	fs.MaxPlayers, _ = strconv.Atoi(kv["maxplayers"])
+	fs.HostPort, _ = strconv.Atoi(kv["hostport"])
+	fs.HostIP = kv["hostip"]
+	fs.Software, fs.Plugins = parsePlugins(kv["plugins"])
+	return fs, nil
+}
+
+// parsePlugins splits the plugins value: "<software>: <plugin>; <plugin>; ..."
+// vanilla servers send an empty value
+func parsePlugins(val string) (string, []string) {
+	var plugins []string
+	parts := strings.SplitN(val, ":", 2)
+	software := strings.TrimSpace(parts[0])
+	if len(parts) < 2 {
+		return software, plugins
+	}
+
+	for _, p := range strings.Split(parts[1], ";") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			plugins = append(plugins, p)
+		}
+	}
+	return software, plugins
+}
+
+// send writes a request and returns the reply payload (after the type and session id)
+func (c *Client) send(ptype byte, payload []byte) ([]byte, error) {
+	var req bytes.Buffer
+	req.Write(magic)
+	req.WriteByte(ptype)
+	binary.Write(&req, binary.BigEndian, c.session)
+	req.Write(payload)
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var buf = make([]byte, 65535)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 5 {
+		return nil, errors.New("query reply too short")
+	}
+	if buf[0] != ptype {
+		return nil, fmt.Errorf("unexpected query reply type %#x", buf[0])
+	}
+	if int32(binary.BigEndian.Uint32(buf[1:5])) != c.session {
+		return nil, errors.New("query reply session mismatch")
+	}
+	return buf[5:n], nil
+}
+
+func readString(rd *bufio.Reader) (string, error) {
+	s, err := rd.ReadString(0x00)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(s, "\x00"), nil
+}
+
+// Full is a convenience wrapper to dial, request the full stat and close
+func Full(host string, port int, timeout time.Duration) (FullStat, error) {
+	c, err := Dial(host, port, timeout)
+	if err != nil {
+		return FullStat{}, err
+	}
+	defer c.Close()
+	return c.Full()
+}
+
+// Basic is a convenience wrapper to dial, request the basic stat and close
+func Basic(host string, port int, timeout time.Duration) (BasicStat, error) {
+	c, err := Dial(host, port, timeout)
+	if err != nil {
+		return BasicStat{}, err
+	}
+	defer c.Close()
+	return c.Basic()
+}
@@ -1,26 +1,39 @@
 package rcon
 
-import mcrcon "github.com/jlmeeker/mc-rcon"
+import (
+	mcrcon "github.com/jlmeeker/mc-rcon"
+	"github.com/jlmeeker/mcmanager/stats"
+)
 
-// Send sends a message to the server's rcon
+// Send sends a message to the server's rcon. Only failures after connecting count as
+// rcon errors, a server that isn't running (or listening) just can't be reached.
 func Send(msg, port, pass string) (string, error) {
+	resp, connected, err := send(msg, port, pass)
+	if err != nil && connected {
+		stats.Inc(stats.RconErrors)
+	}
+	return resp, err
+}
+
+// send runs a command, connected reports whether the connection was made
+func send(msg, port, pass string) (string, bool, error) {
 	conn := new(mcrcon.MCConn)
 	err := conn.Open("localhost:"+port, pass)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	defer conn.Close()
 
 	err = conn.Authenticate()
 	if err != nil {
-		return "", err
+		return "", true, err
 	}
 
 	resp, err := conn.SendCommand(msg)
 	if err != nil {
-		return "", err
+		return "", true, err
 	}
-	return resp, nil
+	return resp, true, nil
 }
 
 /*
@@ -0,0 +1,303 @@
+package rconparse
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Captured replies these parsers are written against (rcon joins multi-line output
+// with or without newlines depending on the server, so both are handled):
+//
+//   vanilla 1.16  list        There are 2 of a max of 20 players online: Steve, Alex
+//   vanilla 1.16  list        There are 0 of a max of 20 players online:
+//   vanilla 1.12  list        There are 1/20 players online:Steve
+//   vanilla 1.16  list uuids  There are 1 of a max of 20 players online: Steve (8667ba71-b85a-4004-af54-457a9734eed7)
+//   paper/spigot  list        §6There are §c2§6 out of maximum §c20§6 players online.\n§6default§r: §4[AFK]§rSteve, ~Alex
+//   paper/spigot  list        There are 3 out of maximum 20 players online.\nadmins: Steve\ndefault: Alex, Herobrine
+//   vanilla 1.16  whitelist   There are 2 whitelisted players: Steve, Alex
+//   vanilla 1.16  whitelist   There are no whitelisted players
+//   vanilla 1.12  whitelist   There are 2 (out of 3 seen) whitelisted players:\nSteve, Alex
+//   vanilla 1.16  banlist     There are 2 ban(s):Steve was banned by Server: Banned by an operator.Alex was banned by Bob: griefing
+//   vanilla 1.16  banlist     There are no bans
+//   paper 1.16    banlist     There are 2 ban(s):\nSteve was banned by Server: Banned by an operator.\nAlex was banned by Bob: griefing
+//   vanilla 1.12  banlist     There are 2 total banned players:\nSteve and Alex
+//   vanilla 1.16  seed        Seed: [-4172144997902289642]
+//   vanilla 1.12  seed        Seed: -4172144997902289642
+//   vanilla 1.16  time query  The time is 13000
+
+// ErrUnrecognized is returned when a reply does not match any known format
+var ErrUnrecognized = errors.New("unrecognized rcon reply")
+
+// PlayerList is the parsed result of the list command
+type PlayerList struct {
+	Online  int                 `json:"online"`
+	Max     int                 `json:"max"`
+	Players []string            `json:"players"`
+	UUIDs   map[string]string   `json:"uuids,omitempty"`
+	Groups  map[string][]string `json:"groups,omitempty"`
+}
+
+// Ban is a single entry of the banlist command
+type Ban struct {
+	Target string `json:"target"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+var (
+	colorCodes   = regexp.MustCompile(`§.`)
+	tagPrefix    = regexp.MustCompile(`^(\[[^\]]*\])+`)
+	listHeader   = regexp.MustCompile(`There are (\d+)(?: of a max of | out of maximum |/)(\d+) players online[.:]?`)
+	uuidSuffix   = regexp.MustCompile(`^(.*?)\s*\(([0-9a-fA-F-]{32,36})\)$`)
+	groupLine    = regexp.MustCompile(`^([^:]+):\s*(.*)$`)
+	wlHeader     = regexp.MustCompile(`There are (?:no|\d+(?: \(out of \d+ seen\))?) whitelisted players:?`)
+	banHeader    = regexp.MustCompile(`There (?:are|is) (?:no bans|\d+ ban(?:\(s\)|s)?:)`)
+	legacyBans   = regexp.MustCompile(`There are \d+ total banned (?:players|IP addresses):`)
+	banMarker    = regexp.MustCompile(` was banned by ([^:\n]+?): `)
+	banTarget    = regexp.MustCompile(`(?:\d{1,3}(?:\.\d{1,3}){3}|[A-Za-z0-9_]{1,16})$`)
+	caseChange   = regexp.MustCompile(`[a-z][A-Z]`)
+	seedReply    = regexp.MustCompile(`Seed: \[?(-?\d+)\]?`)
+	timeReply    = regexp.MustCompile(`The time is (\d+)`)
+	validName    = regexp.MustCompile(`^[A-Za-z0-9_]{1,16}$`)
+	separatorsRe = regexp.MustCompile(`,\s*|\s+and\s+`)
+)
+
+// StripColor removes § formatting codes
+func StripColor(s string) string {
+	return colorCodes.ReplaceAllString(s, "")
+}
+
+// List parses the reply of "list" and "list uuids" (vanilla, spigot and paper formats)
+func List(reply string) (PlayerList, error) {
+	var pl = PlayerList{
+		Players: []string{},
+	}
+	reply = StripColor(reply)
+
+	loc := listHeader.FindStringSubmatchIndex(reply)
+	if loc == nil {
+		return pl, ErrUnrecognized
+	}
+	pl.Online, _ = strconv.Atoi(reply[loc[2]:loc[3]])
+	pl.Max, _ = strconv.Atoi(reply[loc[4]:loc[5]])
+
+	body := strings.TrimSpace(reply[loc[1]:])
+	if body == "" {
+		return pl, nil
+	}
+
+	// paper/essentials put one "group: names" line per group
+	lines := strings.Split(body, "\n")
+	grouped := len(lines) > 1 || (groupLine.MatchString(lines[0]) && !uuidSuffix.MatchString(lines[0]))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var group string
+		if grouped {
+			if m := groupLine.FindStringSubmatch(line); m != nil {
+				group = strings.TrimSpace(m[1])
+				line = m[2]
+			}
+		}
+
+		for _, name := range names(line) {
+			if uuid := uuidSuffix.FindStringSubmatch(name); uuid != nil {
+				name = cleanName(uuid[1])
+				if pl.UUIDs == nil {
+					pl.UUIDs = make(map[string]string)
+				}
+				pl.UUIDs[name] = uuid[2]
+			}
+			pl.Players = append(pl.Players, name)
+			if group != "" {
+				if pl.Groups == nil {
+					pl.Groups = make(map[string][]string)
+				}
+				pl.Groups[group] = append(pl.Groups[group], name)
+			}
+		}
+	}
+
+	return pl, nil
+}
+
+// Whitelist parses the reply of "whitelist list"
+func Whitelist(reply string) ([]string, error) {
+	reply = StripColor(reply)
+	loc := wlHeader.FindStringIndex(reply)
+	if loc == nil {
+		return nil, ErrUnrecognized
+	}
+	return names(reply[loc[1]:]), nil
+}
+
+// Banlist parses the reply of "banlist" (players or ips). Paper puts every entry
+// on its own line, vanilla joins them, so there an entry ends where the next
+// target name starts.
+func Banlist(reply string) ([]Ban, error) {
+	var bans = []Ban{}
+	reply = StripColor(reply)
+
+	if loc := legacyBans.FindStringIndex(reply); loc != nil {
+		for _, n := range names(reply[loc[1]:]) {
+			bans = append(bans, Ban{Target: n})
+		}
+		return bans, nil
+	}
+
+	loc := banHeader.FindStringIndex(reply)
+	if loc == nil {
+		return bans, ErrUnrecognized
+	}
+	for _, line := range strings.Split(reply[loc[1]:], "\n") {
+		bans = append(bans, banLine(strings.TrimSpace(line))...)
+	}
+	return bans, nil
+}
+
+// banLine parses one or more joined "<target> was banned by <source>: <reason>" entries
+func banLine(line string) []Ban {
+	var bans []Ban
+	var rest int // where the text after the last marker starts
+
+	for _, m := range banMarker.FindAllStringSubmatchIndex(line, -1) {
+		var segment = line[rest:m[0]]
+		var split = 0
+		if len(bans) > 0 {
+			split = splitReason(segment)
+			bans[len(bans)-1].Reason = strings.TrimSpace(segment[:split])
+		}
+		bans = append(bans, Ban{
+			Target: strings.TrimSpace(segment[split:]),
+			Source: strings.TrimSpace(line[m[2]:m[3]]),
+		})
+		rest = m[1]
+	}
+	if len(bans) > 0 {
+		bans[len(bans)-1].Reason = strings.TrimSpace(line[rest:])
+	}
+	return bans
+}
+
+// splitReason finds where a reason ends and the next entry's target begins in
+// joined vanilla output. Reasons usually end in punctuation, which names can't
+// contain. Without it, a lowercase letter followed by an uppercase one (like
+// "griefingAlex") is taken as the boundary.
+func splitReason(segment string) int {
+	loc := banTarget.FindStringIndex(segment)
+	if loc == nil {
+		return len(segment)
+	}
+	if loc[0] > 0 && strings.ContainsAny(segment[loc[0]-1:loc[0]], ".!?)]") {
+		return loc[0]
+	}
+	if all := caseChange.FindAllStringIndex(segment[loc[0]:], -1); all != nil {
+		return loc[0] + all[len(all)-1][0] + 1
+	}
+	return loc[0]
+}
+
+// Seed parses the reply of "seed"
+func Seed(reply string) (int64, error) {
+	m := seedReply.FindStringSubmatch(StripColor(reply))
+	if m == nil {
+		return 0, ErrUnrecognized
+	}
+	return strconv.ParseInt(m[1], 10, 64)
+}
+
+// Time parses the reply of "time query <daytime|gametime|day>"
+func Time(reply string) (int64, error) {
+	m := timeReply.FindStringSubmatch(StripColor(reply))
+	if m == nil {
+		return 0, ErrUnrecognized
+	}
+	return strconv.ParseInt(m[1], 10, 64)
+}
+
+// names splits a comma (or newline) separated list of player names
+func names(s string) []string {
+	var result = []string{}
+	s = strings.ReplaceAll(s, "\n", ",")
+	for _, n := range separatorsRe.Split(s, -1) {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if !uuidSuffix.MatchString(n) {
+			n = cleanName(n)
+		}
+		if n != "" {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// cleanName removes tags like [AFK] and nickname markers that plugins prepend to names
+func cleanName(n string) string {
+	n = strings.TrimSpace(n)
+	n = tagPrefix.ReplaceAllString(n, "")
+	if validName.MatchString(n) {
+		return n
+	}
+	return strings.TrimLeft(n, "~*")
+}
+
+// TPS parses the reply of paper/spigot "tps" and returns the 1m, 5m and 15m averages
+//
+//	paper/spigot  tps         §6TPS from last 1m, 5m, 15m: §a20.0, §a19.98, §a*20.0
+func TPS(reply string) ([]float64, error) {
+	reply = StripColor(reply)
+	ndx := strings.Index(reply, "TPS from last")
+	if ndx < 0 {
+		return nil, ErrUnrecognized
+	}
+	colon := strings.Index(reply[ndx:], ":")
+	if colon < 0 {
+		return nil, ErrUnrecognized
+	}
+	return floats(reply[ndx+colon:], 3)
+}
+
+// MSPT parses the reply of paper "mspt" and returns the avg/min/max of the shortest window (5s)
+//
+//	paper         mspt        §6Server tick times §e(§7avg§e/§7min§e/§7max§e)§6 from last 5s§7,§6 10s§7,§6 1m§e:\n§6◴ §a2.1§7/§a1.1§7/§a8.6§e, ...
+func MSPT(reply string) ([]float64, error) {
+	reply = StripColor(reply)
+	ndx := strings.Index(reply, "tick times")
+	if ndx < 0 {
+		return nil, ErrUnrecognized
+	}
+	colon := strings.Index(reply[ndx:], ":")
+	if colon < 0 {
+		return nil, ErrUnrecognized
+	}
+	return floats(reply[ndx+colon:], 3)
+}
+
+var floatRe = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// floats returns the first n decimal numbers found in s
+func floats(s string, n int) ([]float64, error) {
+	var result []float64
+	for _, f := range floatRe.FindAllString(s, -1) {
+		if len(result) == n {
+			break
+		}
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, v)
+	}
+	if len(result) < n {
+		return result, ErrUnrecognized
+	}
+	return result, nil
+}
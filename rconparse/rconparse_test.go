@@ -0,0 +1,210 @@
+package rconparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	var tests = []struct {
+		name  string
+		reply string
+		want  PlayerList
+	}{
+		{
+			name:  "vanilla 1.16",
+			reply: "There are 2 of a max of 20 players online: Steve, Alex",
+			want:  PlayerList{Online: 2, Max: 20, Players: []string{"Steve", "Alex"}},
+		},
+		{
+			name:  "vanilla 1.16 empty",
+			reply: "There are 0 of a max of 20 players online: ",
+			want:  PlayerList{Online: 0, Max: 20, Players: []string{}},
+		},
+		{
+			name:  "vanilla 1.12",
+			reply: "There are 1/20 players online:Steve",
+			want:  PlayerList{Online: 1, Max: 20, Players: []string{"Steve"}},
+		},
+		{
+			name:  "vanilla 1.12 joined names",
+			reply: "There are 3/20 players online:Steve, Alex and Herobrine",
+			want:  PlayerList{Online: 3, Max: 20, Players: []string{"Steve", "Alex", "Herobrine"}},
+		},
+		{
+			name:  "vanilla 1.16 uuids",
+			reply: "There are 1 of a max of 20 players online: Steve (8667ba71-b85a-4004-af54-457a9734eed7)",
+			want: PlayerList{Online: 1, Max: 20, Players: []string{"Steve"},
+				UUIDs: map[string]string{"Steve": "8667ba71-b85a-4004-af54-457a9734eed7"}},
+		},
+		{
+			name:  "paper colored with tags",
+			reply: "§6There are §c2§6 out of maximum §c20§6 players online.\n§6default§r: §4[AFK]§rSteve, ~Alex",
+			want: PlayerList{Online: 2, Max: 20, Players: []string{"Steve", "Alex"},
+				Groups: map[string][]string{"default": {"Steve", "Alex"}}},
+		},
+		{
+			name:  "paper groups",
+			reply: "There are 3 out of maximum 20 players online.\nadmins: Steve\ndefault: Alex, Herobrine",
+			want: PlayerList{Online: 3, Max: 20, Players: []string{"Steve", "Alex", "Herobrine"},
+				Groups: map[string][]string{"admins": {"Steve"}, "default": {"Alex", "Herobrine"}}},
+		},
+		{
+			name:  "paper empty",
+			reply: "§6There are §c0§6 out of maximum §c20§6 players online.",
+			want:  PlayerList{Online: 0, Max: 20, Players: []string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := List(tt.reply)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := List("Unknown command"); err != ErrUnrecognized {
+		t.Errorf("unknown reply: got %v, want ErrUnrecognized", err)
+	}
+}
+
+func TestWhitelist(t *testing.T) {
+	var tests = []struct {
+		name  string
+		reply string
+		want  []string
+	}{
+		{"vanilla 1.16", "There are 2 whitelisted players: Steve, Alex", []string{"Steve", "Alex"}},
+		{"vanilla 1.16 none", "There are no whitelisted players", []string{}},
+		{"vanilla 1.12", "There are 2 (out of 3 seen) whitelisted players:\nSteve and Alex", []string{"Steve", "Alex"}},
+		{"vanilla 1.12 commas", "There are 3 (out of 3 seen) whitelisted players:\nSteve, Alex and Herobrine", []string{"Steve", "Alex", "Herobrine"}},
+	}
+
+	for _, tt := range tests {
+		got, err := Whitelist(tt.reply)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBanlist(t *testing.T) {
+	var tests = []struct {
+		name  string
+		reply string
+		want  []Ban
+	}{
+		{
+			name:  "vanilla 1.16 none",
+			reply: "There are no bans",
+			want:  []Ban{},
+		},
+		{
+			name:  "vanilla 1.16 one",
+			reply: "There are 1 ban(s):Steve was banned by Server: Banned by an operator.",
+			want:  []Ban{{Target: "Steve", Source: "Server", Reason: "Banned by an operator."}},
+		},
+		{
+			name:  "vanilla 1.16 joined",
+			reply: "There are 2 ban(s):Steve was banned by Server: Banned by an operator.Alex was banned by Bob: griefing",
+			want: []Ban{
+				{Target: "Steve", Source: "Server", Reason: "Banned by an operator."},
+				{Target: "Alex", Source: "Bob", Reason: "griefing"},
+			},
+		},
+		{
+			name:  "vanilla 1.16 joined without punctuation",
+			reply: "There are 2 ban(s):Steve was banned by Bob: griefingAlex was banned by Rcon: Banned by an operator.",
+			want: []Ban{
+				{Target: "Steve", Source: "Bob", Reason: "griefing"},
+				{Target: "Alex", Source: "Rcon", Reason: "Banned by an operator."},
+			},
+		},
+		{
+			name:  "vanilla 1.16 ips",
+			reply: "There are 2 ban(s):Steve was banned by Server: Banned by an operator.192.168.1.20 was banned by Bob: spam",
+			want: []Ban{
+				{Target: "Steve", Source: "Server", Reason: "Banned by an operator."},
+				{Target: "192.168.1.20", Source: "Bob", Reason: "spam"},
+			},
+		},
+		{
+			name:  "paper 1.16 lines",
+			reply: "There are 3 ban(s):\nSteve was banned by Server: Banned by an operator.\nAlex was banned by Bob: griefing\nHerobrine was banned by Rcon: hacking",
+			want: []Ban{
+				{Target: "Steve", Source: "Server", Reason: "Banned by an operator."},
+				{Target: "Alex", Source: "Bob", Reason: "griefing"},
+				{Target: "Herobrine", Source: "Rcon", Reason: "hacking"},
+			},
+		},
+		{
+			name:  "vanilla 1.12",
+			reply: "There are 2 total banned players:\nSteve and Alex",
+			want:  []Ban{{Target: "Steve"}, {Target: "Alex"}},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := Banlist(tt.reply)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Banlist("Unknown command"); err != ErrUnrecognized {
+		t.Errorf("unknown reply: got %v, want ErrUnrecognized", err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	var tests = []struct {
+		name  string
+		reply string
+		want  int64
+	}{
+		{"vanilla 1.16", "Seed: [-4172144997902289642]", -4172144997902289642},
+		{"vanilla 1.12", "Seed: -4172144997902289642", -4172144997902289642},
+		{"paper colored", "§fSeed: [§a1234567890§f]", 1234567890},
+	}
+
+	for _, tt := range tests {
+		got, err := Seed(tt.reply)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	var tests = []struct {
+		name  string
+		reply string
+		want  int64
+	}{
+		{"daytime", "The time is 13000", 13000},
+		{"gametime", "The time is 1830291", 1830291},
+	}
+
+	for _, tt := range tests {
+		got, err := Time(tt.reply)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := Time("Unknown command"); err != ErrUnrecognized {
+		t.Errorf("unknown reply: got %v, want ErrUnrecognized", err)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBudget is the total heap all running servers may reserve (0 only checks /proc/meminfo)
+var MemoryBudget int64
+
+// startGrace is how long a started server holds its reservation while the JVM comes up
+const startGrace = 3 * time.Minute
+
+var (
+	admitMu  sync.Mutex
+	starting = make(map[string]time.Time)
+)
+
+// AdmissionError is returned when a server can't be started because the host lacks memory
+type AdmissionError struct {
+	Reason string
+}
+
+func (e AdmissionError) Error() string {
+	return "not enough memory to start: " + e.Reason
+}
+
+// admit checks that the host has room for the server and reserves it,
+// the caller must call release if the server doesn't end up starting
+func admit(s *Server) error {
+	admitMu.Lock()
+	defer admitMu.Unlock()
+
+	var need = s.memory()
+	var committed, pending int64
+	for _, other := range Servers.All() {
+		if other.UUID == s.UUID || other.Deleted {
+			continue
+		}
+		if t, ok := starting[other.UUID]; ok && time.Since(t) < startGrace {
+			committed += other.memory()
+			pending += other.memory()
+		} else if other.IsRunning() {
+			committed += other.memory()
+		}
+	}
+
+	if MemoryBudget > 0 && committed+need > MemoryBudget {
+		return AdmissionError{fmt.Sprintf("running servers use %s of the %s budget, this one needs %s",
+			formatSize(committed), formatSize(MemoryBudget), formatSize(need))}
+	}
+
+	// servers still starting haven't touched their heap yet, MemAvailable still counts it
+	if avail, err := memAvailable(); err == nil && avail-pending < need {
+		if avail -= pending; avail < 0 {
+			avail = 0
+		}
+		return AdmissionError{fmt.Sprintf("host has %s available, this server needs %s", formatSize(avail), formatSize(need))}
+	}
+
+	starting[s.UUID] = time.Now()
+	return nil
+}
+
+// release drops the start reservation of a server
+func release(id string) {
+	admitMu.Lock()
+	delete(starting, id)
+	admitMu.Unlock()
+}
+
+// memAvailable reads MemAvailable from /proc/meminfo
+func memAvailable() (int64, error) {
+	fh, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, fmt.Errorf("MemAvailable not found in /proc/meminfo")
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/ports"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Clone copies the server (world, configs and plugins) into a new server owned by owner
+// with fresh ports and rcon password. An empty commit clones the current files, otherwise
+// the files of that backup commit are used. The clone is not started.
+func (s *Server) Clone(owner string, formData forms.Clone) (*Server, error) {
+	var c = &Server{
+		Description: s.Description,
+		Flavor:      s.Flavor,
+		MaxMem:      s.MaxMem,
+		MinMem:      s.MinMem,
+		Name:        strings.TrimSpace(formData.Name),
+		Owner:       owner,
+		Release:     s.Release,
+		Tags:        append([]string(nil), s.Tags...),
+	}
+	nameMu.Lock()
+	var err error
+	if c.Name == "" {
+		c.Name = uniqueName(owner, copyName(s.Name))
+	}
+	if err = validateName(owner, c.Name, ""); err != nil {
+		nameMu.Unlock()
+		return nil, err
+	}
+	release := reserveName(owner, c.Name)
+	nameMu.Unlock()
+	defer release()
+
+	suuid, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	c.UUID = suuid.String()
+
+	var assigned ports.Assignment
+	var password string
+	var pUUID string
+	for err == nil {
+		assigned, err = ports.Allocate(c.UUID, formData.Port)
+		if err != nil {
+			break
+		}
+
+		if formData.Commit != "" {
+			s.report(fmt.Sprintf("copying files from backup %s", formData.Commit))
+			err = storage.CloneServerBackup(s.UUID, formData.Commit, c.UUID)
+		} else {
+			if s.IsRunning() {
+				s.report("saving world")
+				if _, serr := s.Rcon("save-all flush"); serr != nil {
+					log.Printf("clone: unable to save %s before copying: %s", s.UUID, serr.Error())
+				}
+			}
+			s.report("copying files")
+			err = storage.CloneServer(s.UUID, c.UUID)
+		}
+		if err != nil {
+			break
+		}
+
+		s.report("writing server files")
+		if password, err = newRconPassword(); err != nil {
+			break
+		}
+		if err = c.RefreshProperties(); err != nil {
+			break
+		}
+		c.Props.set("rcon.password", password)
+		c.Props.setPorts(assigned)
+		if err = c.SaveProps(); err != nil {
+			break
+		}
+		if err = storage.DeployJar(c.Flavor, c.Release, c.UUID); err != nil {
+			break
+		}
+
+		if pUUID, err = auth.PlayerUUID(owner); err != nil {
+			break
+		}
+		c.OwnerUUID = pUUID
+		if err = c.SaveManagedJSON(); err != nil {
+			break
+		}
+		if !c.PlayerIsOp(owner) {
+			if err = c.AddOpOffline(owner, pUUID, true); err != nil {
+				break
+			}
+			if c.WhitelistEnabled() && !c.PlayerIsWhitelisted(owner) {
+				if err = c.AddWhitelistOffline(owner, pUUID, true); err != nil {
+					break
+				}
+			}
+		}
+
+		s.report("setting up backups")
+		err = storage.SetupServerBackup(c.UUID)
+		break
+	}
+
+	if err != nil {
+		ports.Release(c.UUID)
+		storage.DeleteServer(c.UUID)
+		return nil, err
+	}
+
+	Servers.Put(c)
+	storage.AuditWrite(owner, "clone", fmt.Sprintf("cloned server %s into %s", s.UUID, c.UUID))
+	return c, nil
+}
+
+// copyName is the default name of a copy of a server called name. Characters that
+// names may no longer contain are dropped and it is shortened to leave room for
+// the " (copy)" suffix and a number from uniqueName.
+func copyName(name string) string {
+	const suffix = " (copy)"
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if invalidNameRune(r) {
+			return -1
+		}
+		return r
+	}, name))
+
+	var room = MaxNameLength - len(suffix) - len(" 999")
+	for len(name) > room {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return strings.TrimSpace(name) + suffix
+}
+
+// newRconPassword generates a random rcon password
+func newRconPassword() (string, error) {
+	var b = make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jlmeeker/mcmanager/forms"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Limits on server metadata
+const (
+	MaxNameLength        = 64
+	MaxDescriptionLength = 512
+	MaxNotesLength       = 4096
+	MaxTags              = 16
+	MaxTagLength         = 32
+)
+
+// Metadata validation errors
+var (
+	ErrNameEmpty   = errors.New("name cannot be empty")
+	ErrNameTaken   = errors.New("you already have a server with that name")
+	ErrNameTooLong = fmt.Errorf("name cannot be longer than %d characters", MaxNameLength)
+	ErrNameInvalid = fmt.Errorf("name may only contain letters, numbers, spaces and %s", namePunctuation)
+	ErrMetaTooLong = errors.New("description, notes or tags are too long")
+)
+
+// namePunctuation is the punctuation allowed in server names besides spaces
+const namePunctuation = "-_.,:!#+()"
+
+// nameMu serializes name checks with the renames/creates that depend on them
+var nameMu sync.Mutex
+
+// reserved holds the names of servers that are still being set up (by nameKey), guarded by nameMu
+var reserved = make(map[string]bool)
+
+// NameAvailable reports whether owner has no other (non-deleted) server called name, exceptID is ignored,
+// the caller must hold nameMu
+func NameAvailable(owner, name, exceptID string) bool {
+	if reserved[nameKey(owner, name)] {
+		return false
+	}
+	for _, s := range Servers.All() {
+		if s.UUID != exceptID && !s.Deleted && s.IsPrimaryOwner(owner) && strings.EqualFold(s.Name, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateName checks a server name for owner, exceptID is the server being renamed (if any)
+func ValidateName(owner, name, exceptID string) error {
+	nameMu.Lock()
+	defer nameMu.Unlock()
+	return validateName(owner, strings.TrimSpace(name), exceptID)
+}
+
+// validateName checks a (trimmed) server name for owner
+func validateName(owner, name, exceptID string) error {
+	switch {
+	case name == "":
+		return ErrNameEmpty
+	case len(name) > MaxNameLength:
+		return ErrNameTooLong
+	case strings.IndexFunc(name, invalidNameRune) >= 0:
+		return ErrNameInvalid
+	case !NameAvailable(owner, name, exceptID):
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// invalidNameRune reports whether r may not be used in a server name
+func invalidNameRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && !strings.ContainsRune(namePunctuation, r)
+}
+
+// nameKey is the reserved key of a server name, names are unique per owner regardless of case
+func nameKey(owner, name string) string {
+	return strings.ToLower(owner) + "/" + strings.ToLower(name)
+}
+
+// reserveName holds name for owner until the returned release is called so a server
+// being set up keeps its name until it is registered (caller must hold nameMu)
+func reserveName(owner, name string) func() {
+	var key = nameKey(owner, name)
+	reserved[key] = true
+	return func() {
+		nameMu.Lock()
+		delete(reserved, key)
+		nameMu.Unlock()
+	}
+}
+
+// uniqueName returns base, or base with a number appended, so that it's available for owner
+func uniqueName(owner, base string) string {
+	var name = base
+	for i := 2; !NameAvailable(owner, name, ""); i++ {
+		name = fmt.Sprintf("%s %d", base, i)
+	}
+	return name
+}
+
+// parseTags splits a comma separated list of tags, dropping blanks and duplicates
+func parseTags(raw string) []string {
+	var tags = []string{}
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" && !inList(t, tags) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// Edit updates the name, description, tags and owner notes of the server
+func (s *Server) Edit(editor string, formData forms.Edit) error {
+	var name = strings.TrimSpace(formData.Name)
+	var description = strings.TrimSpace(formData.Description)
+	var notes = strings.TrimSpace(formData.Notes)
+	var tags = parseTags(formData.Tags)
+
+	if len(description) > MaxDescriptionLength || len(notes) > MaxNotesLength || len(tags) > MaxTags {
+		return ErrMetaTooLong
+	}
+	for _, t := range tags {
+		if len(t) > MaxTagLength {
+			return ErrMetaTooLong
+		}
+	}
+
+	nameMu.Lock()
+	defer nameMu.Unlock()
+
+	if err := validateName(s.Owner, name, s.UUID); err != nil {
+		return err
+	}
+
+	var oldName = s.Name
+	var owner = s.IsOwner(editor)
+	s.mu.Lock()
+	s.Name = name
+	s.Description = description
+	s.Tags = tags
+	// notes are the owner's, other editors never see them so they can't change them
+	if owner {
+		s.Notes = notes
+	}
+	s.mu.Unlock()
+	if err := s.SaveManagedJSON(); err != nil {
+		return err
+	}
+
+	if oldName != name {
+		storage.AuditWrite(editor, "rename", fmt.Sprintf("renamed server %s from %q to %q", s.UUID, oldName, name))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Ownership errors
+var (
+	ErrAlreadyOwner   = errors.New("player already owns this server")
+	ErrNotCoOwner     = errors.New("player is not a co-owner of this server")
+	ErrUnknownPlayer  = errors.New("unknown player")
+	ErrOwnerNameTaken = errors.New("the new owner already has a server with that name")
+)
+
+// CoOwner is a player with full owner permissions on a server they don't own.
+// Players are matched by Mojang UUID, Name is the last known name.
+type CoOwner struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// IsPrimaryOwner returns if a given player is the server owner (not a co-owner)
+func (s *Server) IsPrimaryOwner(player string) bool {
+	return auth.SamePlayer(player, s.Owner, s.OwnerUUID)
+}
+
+// IsCoOwner returns if a given player is one of the server co-owners
+func (s *Server) IsCoOwner(player string) bool {
+	for _, co := range s.CoOwners {
+		if auth.SamePlayer(player, co.Name, co.UUID) {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnerName returns the current name of the owner
+func (s *Server) OwnerName() string {
+	if name := auth.KnownName(s.OwnerUUID); name != "" {
+		return name
+	}
+	return s.Owner
+}
+
+// CoOwnerNames returns the current names of the co-owners
+func (s *Server) CoOwnerNames() []string {
+	var names = []string{}
+	for _, co := range s.CoOwners {
+		if name := auth.KnownName(co.UUID); name != "" {
+			names = append(names, name)
+		} else {
+			names = append(names, co.Name)
+		}
+	}
+	return names
+}
+
+// TransferOwnership makes another player the owner of the server,
+// keep leaves the previous owner on as a co-owner
+func (s *Server) TransferOwnership(actor, player string, keep bool) error {
+	player = strings.TrimSpace(player)
+	uuid, err := auth.PlayerUUID(player)
+	if err != nil {
+		return ErrUnknownPlayer
+	}
+	if uuid == s.OwnerUUID || s.IsPrimaryOwner(player) {
+		return ErrAlreadyOwner
+	}
+
+	nameMu.Lock()
+	defer nameMu.Unlock()
+	if !NameAvailable(player, s.Name, s.UUID) {
+		return ErrOwnerNameTaken
+	}
+
+	var previous = CoOwner{UUID: s.OwnerUUID, Name: s.OwnerName()}
+	s.mu.Lock()
+	s.removeCoOwner(player, uuid)
+	s.Owner = player
+	s.OwnerUUID = uuid
+	if keep {
+		s.CoOwners = append(s.CoOwners, previous)
+	}
+	s.mu.Unlock()
+
+	if err = s.SaveManagedJSON(); err != nil {
+		return err
+	}
+	storage.AuditWrite(actor, "owner:transfer", fmt.Sprintf("transferred server %s from %s (%s) to %s (%s)", s.UUID, previous.Name, previous.UUID, player, uuid))
+	s.ensureOp(player, uuid)
+	return nil
+}
+
+// AddCoOwner gives another player full owner permissions on the server
+func (s *Server) AddCoOwner(actor, player string) error {
+	player = strings.TrimSpace(player)
+	uuid, err := auth.PlayerUUID(player)
+	if err != nil {
+		return ErrUnknownPlayer
+	}
+	if uuid == s.OwnerUUID || s.IsOwner(player) {
+		return ErrAlreadyOwner
+	}
+
+	s.mu.Lock()
+	s.CoOwners = append(s.CoOwners, CoOwner{UUID: uuid, Name: player})
+	s.mu.Unlock()
+	if err = s.SaveManagedJSON(); err != nil {
+		return err
+	}
+	storage.AuditWrite(actor, "coowner:add", fmt.Sprintf("added co-owner %s (%s) to server %s", player, uuid, s.UUID))
+	s.ensureOp(player, uuid)
+	return nil
+}
+
+// RemoveCoOwner takes owner permissions away from a co-owner (they stay op)
+func (s *Server) RemoveCoOwner(actor, player string) error {
+	player = strings.TrimSpace(player)
+	s.mu.Lock()
+	removed := s.removeCoOwner(player, auth.KnownUUID(player))
+	s.mu.Unlock()
+	if !removed {
+		return ErrNotCoOwner
+	}
+
+	if err := s.SaveManagedJSON(); err != nil {
+		return err
+	}
+	storage.AuditWrite(actor, "coowner:remove", fmt.Sprintf("removed co-owner %s from server %s", player, s.UUID))
+	return nil
+}
+
+// AdoptOwnerUUIDs records the UUID of a player on servers they own from before
+// owners were tracked by UUID
+func AdoptOwnerUUIDs(player, uuid string) {
+	if uuid == "" {
+		return
+	}
+
+	for _, s := range Servers.All() {
+		if s.OwnerUUID != "" || s.Owner != player {
+			continue
+		}
+		err := Servers.Do(s.UUID, func(s *Server) error {
+			s.mu.Lock()
+			s.OwnerUUID = uuid
+			s.mu.Unlock()
+			return s.SaveManagedJSON()
+		})
+		if err != nil {
+			log.Printf("unable to record owner uuid of %s: %s", s.UUID, err.Error())
+		}
+	}
+}
+
+// removeCoOwner drops a co-owner matched by UUID or name, returns if one was removed.
+// Callers must hold s.mu.
+func (s *Server) removeCoOwner(player, uuid string) bool {
+	var kept = []CoOwner{}
+	for _, co := range s.CoOwners {
+		if (uuid != "" && co.UUID == uuid) || auth.SamePlayer(player, co.Name, co.UUID) {
+			continue
+		}
+		kept = append(kept, co)
+	}
+	var removed = len(kept) != len(s.CoOwners)
+	s.CoOwners = kept
+	return removed
+}
+
+// ensureOp makes a player op of the server, online if it's running
+func (s *Server) ensureOp(player, uuid string) {
+	if s.PlayerIsOp(player) {
+		return
+	}
+
+	var err error
+	if s.IsRunning() {
+		err = s.AddOpOnline(player)
+	} else {
+		err = s.AddOpOffline(player, uuid, true)
+	}
+	if err != nil {
+		log.Printf("unable to op %s on %s: %s", player, s.UUID, err.Error())
+	}
+}
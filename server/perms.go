@@ -1,5 +1,30 @@
 package server
 
+// TrashActions are the only actions allowed on deleted servers
+var TrashActions = []string{"und", "prg"}
+
+// PrimaryOwnerActions are owner actions co-owners don't get
+var PrimaryOwnerActions = []string{"own"}
+
+// OwnerOnlyActions can't be part of custom roles, granting roles is left to the built-in admin role
+var OwnerOnlyActions = []string{"coa", "cor", "del", "own", "prg", "rdf", "rol", "und"}
+
+// Built-in roles, each one can do everything the one before it can
+const (
+	RoleViewer    = "viewer"
+	RoleModerator = "moderator"
+	RoleOperator  = "operator"
+	RoleAdmin     = "admin"
+)
+
+// BuiltinRoles are the actions of the built-in roles, in-game ops without a role are operators
+var BuiltinRoles = map[string][]string{
+	RoleViewer:    {"metrics", "sessions"},
+	RoleModerator: {"metrics", "sessions", "adw", "day", "sav", "wea"},
+	RoleOperator:  {"metrics", "sessions", "adw", "day", "sav", "wea", "ado", "bkp"},
+	RoleAdmin:     {"metrics", "sessions", "adw", "day", "sav", "wea", "ado", "bkp", "backups", "cln", "edt", "rgn", "roles", "rol", "sta", "sto", "upg"},
+}
+
 type Permission struct {
 	Name           string `json:"name"`
 	Allowed        bool   `json:"allowed"`
@@ -13,46 +38,71 @@ func newPermissions() Permissions {
 	p["ado"] = Permission{Name: "Add Op", RequireRunning: true}
 	p["adw"] = Permission{Name: "Add Whitelist", RequireRunning: true}
 	p["bkp"] = Permission{Name: "Backup"}
+	p["cln"] = Permission{Name: "Clone"}
 	p["day"] = Permission{Name: "Set Time Day", RequireRunning: true}
 	p["sav"] = Permission{Name: "Save", RequireRunning: true}
 	p["wea"] = Permission{Name: "Weather Clear", RequireRunning: true}
+	p["coa"] = Permission{Name: "Add Co-Owner"}
+	p["cor"] = Permission{Name: "Remove Co-Owner"}
 	p["del"] = Permission{Name: "Delete"}
+	p["edt"] = Permission{Name: "Edit Details"}
 	p["rgn"] = Permission{Name: "Regen World"}
+	p["rol"] = Permission{Name: "Grant Roles"}
+	p["rdf"] = Permission{Name: "Define Custom Roles"}
 	p["sta"] = Permission{Name: "Start"}
 	p["sto"] = Permission{Name: "Stop", RequireRunning: true}
 	p["upg"] = Permission{Name: "Upgrade to latest release"}
+	p["und"] = Permission{Name: "Restore from trash"}
+	p["prg"] = Permission{Name: "Purge permanently"}
+	p["own"] = Permission{Name: "Transfer Ownership"}
+
+	// read-only views (GET)
+	p["backups"] = Permission{Name: "View Backups"}
+	p["metrics"] = Permission{Name: "View Metrics"}
+	p["roles"] = Permission{Name: "View Roles"}
+	p["sessions"] = Permission{Name: "View Player Sessions"}
 	return p
 }
 
+// PermissionsOp are the permissions of an in-game op without a role
 func PermissionsOp() Permissions {
-	var allowed = []string{
-		"ado",
-		"adw",
-		"bkp",
-		"day",
-		"sav",
-		"wea",
-	}
-
-	return allowPerms(allowed, newPermissions())
+	return PermissionsRole(BuiltinRoles[RoleOperator])
 }
 
+// PermissionsOwner are the permissions of owners and co-owners
 func PermissionsOwner() Permissions {
-	var allowed = []string{
-		"del",
-		"rgn",
-		"sta",
-		"sto",
-		"upg",
+	var p = newPermissions()
+	var allowed []string
+	for key := range p {
+		if !inList(key, PrimaryOwnerActions) {
+			allowed = append(allowed, key)
+		}
 	}
 
-	return allowPerms(allowed, PermissionsOp())
+	return allowPerms(allowed, p)
 }
 
 func PermissionsPlayer() Permissions {
 	return newPermissions()
 }
 
+// PermissionsRole are the permissions of a role with the given actions
+func PermissionsRole(actions []string) Permissions {
+	return allowPerms(actions, newPermissions())
+}
+
+// IsAction reports whether a key is a known server action or view
+func IsAction(action string) bool {
+	_, ok := newPermissions()[action]
+	return ok
+}
+
+// grantable reports whether an action can be part of a custom role
+func grantable(action string) bool {
+	_, ok := newPermissions()[action]
+	return ok && !inList(action, OwnerOnlyActions)
+}
+
 func allowPerms(allowed []string, p Permissions) Permissions {
 	for _, key := range allowed {
 		perm := p[key]
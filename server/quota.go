@@ -0,0 +1,222 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// DefaultMemory is the JVM heap of servers that don't set one
+const DefaultMemory = "6G"
+
+// CreatePolicy controls who may create servers and how much each player may own.
+// Admins are exempt. Zero limits are unlimited, an empty Creators list lets anyone create.
+var CreatePolicy struct {
+	Creators   []string
+	MaxServers int
+	MaxMemory  int64
+	MaxDisk    int64
+}
+
+// pendingUsage is the share of the quotas taken by creates that are still running, per player
+var (
+	pendingUsage = make(map[string]Usage)
+	quotaMu      sync.Mutex
+)
+
+// Creation policy errors
+var (
+	ErrNotCreator = errors.New("you are not allowed to create servers")
+	ErrMemory     = errors.New("invalid memory size, use a number followed by M or G (e.g. 4G)")
+)
+
+// QuotaError is returned when creating a server would exceed one of the player's quotas
+type QuotaError struct {
+	What  string
+	Used  string
+	Limit string
+}
+
+func (e QuotaError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: using %s of %s", e.What, e.Used, e.Limit)
+}
+
+// Usage is what a player's servers take up, counted against their quotas
+type Usage struct {
+	Servers int   `json:"servers"`
+	Memory  int64 `json:"memory"`
+	Disk    int64 `json:"disk"`
+}
+
+// Quota is a player's creation allowance, for display on the new server form
+type Quota struct {
+	Allowed    bool  `json:"allowed"`
+	Exempt     bool  `json:"exempt"`
+	Usage      Usage `json:"usage"`
+	MaxServers int   `json:"maxservers"`
+	MaxMemory  int64 `json:"maxmemory"`
+	MaxDisk    int64 `json:"maxdisk"`
+}
+
+// ParseMemory converts a JVM memory size (512M, 6G) to bytes
+func ParseMemory(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	if len(size) < 2 {
+		return 0, ErrMemory
+	}
+
+	var unit int64
+	switch size[len(size)-1] {
+	case 'M':
+		unit = 1 << 20
+	case 'G':
+		unit = 1 << 30
+	default:
+		return 0, ErrMemory
+	}
+
+	n, err := strconv.ParseInt(size[:len(size)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, ErrMemory
+	}
+	return n * unit, nil
+}
+
+// memory returns the heap size of the server in bytes
+func (s *Server) memory() int64 {
+	var size = s.MaxMem
+	if size == "" {
+		size = DefaultMemory
+	}
+	n, _ := ParseMemory(size)
+	return n
+}
+
+// PlayerUsage adds up the servers owned by a player and the creates still running for them,
+// deleted servers only count towards disk
+func PlayerUsage(player string) Usage {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	return usage(player)
+}
+
+// usage is PlayerUsage, callers must hold quotaMu
+func usage(player string) Usage {
+	var u = pendingUsage[player]
+	for _, s := range Servers.All() {
+		if !s.IsPrimaryOwner(player) {
+			continue
+		}
+		u.Disk += storage.DirSize(s.ServerDir())
+		if !s.Deleted {
+			u.Servers++
+			u.Memory += s.memory()
+		}
+	}
+	return u
+}
+
+// CanCreate reports whether a player is allowed to create servers at all
+func CanCreate(player string) bool {
+	if len(CreatePolicy.Creators) == 0 || auth.IsAdmin(player) {
+		return true
+	}
+	for _, c := range CreatePolicy.Creators {
+		if strings.EqualFold(c, player) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlayerQuota returns the creation allowance of a player
+func PlayerQuota(player string) Quota {
+	return Quota{
+		Allowed:    CanCreate(player),
+		Exempt:     auth.IsAdmin(player),
+		Usage:      PlayerUsage(player),
+		MaxServers: CreatePolicy.MaxServers,
+		MaxMemory:  CreatePolicy.MaxMemory,
+		MaxDisk:    CreatePolicy.MaxDisk,
+	}
+}
+
+// ReserveCreate checks that a player may create a server with the given memory and
+// (estimated) disk size without going over their quotas, and holds that share of the
+// quotas until release is called so requests sent while the create runs count it
+func ReserveCreate(player, memory string, disk int64) (release func(), err error) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+
+	mem, err := checkCreate(player, memory, disk)
+	if err != nil {
+		return nil, err
+	}
+	var pending = Usage{Servers: 1, Memory: mem, Disk: disk}
+	pendingUsage[player] = addUsage(pendingUsage[player], pending, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			quotaMu.Lock()
+			defer quotaMu.Unlock()
+			if left := addUsage(pendingUsage[player], pending, -1); left.Servers > 0 {
+				pendingUsage[player] = left
+			} else {
+				delete(pendingUsage, player)
+			}
+		})
+	}, nil
+}
+
+// checkCreate checks a create against the player's quotas and returns its memory size
+// in bytes, callers must hold quotaMu
+func checkCreate(player, memory string, disk int64) (int64, error) {
+	if memory == "" {
+		memory = DefaultMemory
+	}
+	mem, err := ParseMemory(memory)
+	if err != nil {
+		return 0, err
+	}
+
+	if !CanCreate(player) {
+		return 0, ErrNotCreator
+	}
+	if auth.IsAdmin(player) {
+		return mem, nil
+	}
+
+	var u = usage(player)
+	if CreatePolicy.MaxServers > 0 && u.Servers+1 > CreatePolicy.MaxServers {
+		return 0, QuotaError{"server", strconv.Itoa(u.Servers), strconv.Itoa(CreatePolicy.MaxServers)}
+	}
+	if CreatePolicy.MaxMemory > 0 && u.Memory+mem > CreatePolicy.MaxMemory {
+		return 0, QuotaError{"memory", formatSize(u.Memory), formatSize(CreatePolicy.MaxMemory)}
+	}
+	if CreatePolicy.MaxDisk > 0 && u.Disk+disk > CreatePolicy.MaxDisk {
+		return 0, QuotaError{"disk", formatSize(u.Disk), formatSize(CreatePolicy.MaxDisk)}
+	}
+	return mem, nil
+}
+
+// addUsage adds (sign 1) or subtracts (sign -1) b from a
+func addUsage(a, b Usage, sign int) Usage {
+	a.Servers += sign * b.Servers
+	a.Memory += int64(sign) * b.Memory
+	a.Disk += int64(sign) * b.Disk
+	return a
+}
+
+// formatSize formats bytes for quota messages
+func formatSize(n int64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	}
+	return fmt.Sprintf("%dM", n>>20)
+}
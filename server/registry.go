@@ -0,0 +1,228 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/events"
+)
+
+// Change kinds published on the event bus as "server_<kind>"
+const (
+	ChangeAdded   = "added"
+	ChangeUpdated = "updated"
+	ChangeRemoved = "removed"
+)
+
+// Registry is the set of managed servers, safe for concurrent use.
+// Get and All hand out snapshots that readers may use freely, changes are only
+// made through Do, which serializes lifecycle operations on a server and passes
+// the registered server itself.
+type Registry struct {
+	mu      sync.RWMutex
+	servers map[string]*Server
+	locks   map[string]*sync.Mutex
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		servers: make(map[string]*Server),
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+// Get returns a snapshot of a server by UUID
+func (r *Registry) Get(id string) (*Server, bool) {
+	r.mu.RLock()
+	s, ok := r.servers[id]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return s.snapshot(), true
+}
+
+// All returns snapshots of every server (including deleted ones), sorted by name
+func (r *Registry) All() []*Server {
+	r.mu.RLock()
+	var live = make([]*Server, 0, len(r.servers))
+	for _, s := range r.servers {
+		live = append(live, s)
+	}
+	r.mu.RUnlock()
+
+	var result = make([]*Server, 0, len(live))
+	for _, s := range live {
+		result = append(result, s.snapshot())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Put adds (or replaces) a server, the caller must not change it afterwards other than through Do
+func (r *Registry) Put(s *Server) {
+	r.mu.Lock()
+	_, exists := r.servers[s.UUID]
+	r.servers[s.UUID] = s
+	if _, ok := r.locks[s.UUID]; !ok {
+		r.locks[s.UUID] = &sync.Mutex{}
+	}
+	r.mu.Unlock()
+
+	if exists {
+		r.Notify(s.UUID, ChangeUpdated)
+	} else {
+		r.Notify(s.UUID, ChangeAdded)
+	}
+}
+
+// Remove drops a server from the registry (does not touch disk)
+func (r *Registry) Remove(id string) {
+	r.mu.Lock()
+	_, exists := r.servers[id]
+	delete(r.servers, id)
+	r.mu.Unlock()
+
+	if exists {
+		r.Notify(id, ChangeRemoved)
+	}
+}
+
+// Do runs fn with the server's lifecycle lock held, so start/stop/upgrade/regen
+// and friends never run concurrently on the same server. fn gets the registered
+// server, changes to its fields must be made holding its mu (see Server).
+// An update is published afterwards.
+func (r *Registry) Do(id string, fn func(s *Server) error) error {
+	r.mu.RLock()
+	s, ok := r.servers[id]
+	lock := r.locks[id]
+	r.mu.RUnlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	lock.Lock()
+	err := fn(s)
+	lock.Unlock()
+
+	r.Notify(id, ChangeUpdated)
+	return err
+}
+
+// WatchProperties re-reads server.properties of every server on the given interval so
+// changes made while it runs (like an in-game /whitelist on) show up before the next
+// start (expected to be run as a goroutine)
+func WatchProperties(interval time.Duration) {
+	for {
+		for _, s := range Servers.All() {
+			if !s.Deleted {
+				Servers.refreshProperties(s.UUID)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// refreshProperties re-reads a server's properties holding its lifecycle lock, so an
+// operation writing them isn't undone, and publishes an update if they changed
+func (r *Registry) refreshProperties(id string) {
+	r.mu.RLock()
+	s, ok := r.servers[id]
+	lock := r.locks[id]
+	r.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	lock.Lock()
+	s.mu.RLock()
+	var before = s.Props
+	s.mu.RUnlock()
+	err := s.RefreshProperties()
+	s.mu.RLock()
+	var changed = err == nil && !sameProperties(before, s.Props)
+	s.mu.RUnlock()
+	lock.Unlock()
+
+	if changed {
+		r.Notify(id, ChangeUpdated)
+	}
+}
+
+// sameProperties reports whether two sets of properties are equal
+func sameProperties(a, b Properties) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Notify publishes a change of a server on the event bus
+func (r *Registry) Notify(id, kind string) {
+	events.Publish(events.Event{Type: "server_" + kind, ServerID: id})
+}
+
+// snapshot returns a copy of the server that shares no slices or maps with it
+func (s *Server) snapshot() *Server {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var c = &Server{
+		AutoStart:   s.AutoStart,
+		CoOwners:    append([]CoOwner(nil), s.CoOwners...),
+		Deleted:     s.Deleted,
+		DeletedAt:   s.DeletedAt,
+		Description: s.Description,
+		Flavor:      s.Flavor,
+		Grants:      append([]RoleGrant(nil), s.Grants...),
+		MaxMem:      s.MaxMem,
+		MinMem:      s.MinMem,
+		Name:        s.Name,
+		Notes:       s.Notes,
+		Owner:       s.Owner,
+		OwnerUUID:   s.OwnerUUID,
+		Release:     s.Release,
+		Tags:        append([]string(nil), s.Tags...),
+		UUID:        s.UUID,
+	}
+	if s.Props != nil {
+		c.Props = make(Properties, len(s.Props))
+		for k, v := range s.Props {
+			c.Props[k] = v
+		}
+	}
+	if s.Roles != nil {
+		c.Roles = make(map[string][]string, len(s.Roles))
+		for k, v := range s.Roles {
+			c.Roles[k] = append([]string(nil), v...)
+		}
+	}
+	return c
+}
+
+// sync adds servers found on disk that aren't registered yet and drops ones whose
+// directories are gone. Registered servers are kept as-is, in-memory state is authoritative.
+func (r *Registry) sync(loaded map[string]*Server) {
+	for id, s := range loaded {
+		if _, ok := r.Get(id); !ok {
+			r.Put(s)
+		}
+	}
+
+	for _, s := range r.All() {
+		if _, ok := loaded[s.UUID]; !ok {
+			r.Remove(s.UUID)
+		}
+	}
+}
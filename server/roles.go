@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Role errors
+var (
+	ErrUnknownRole    = errors.New("unknown role")
+	ErrRoleName       = errors.New("role names are 1-32 lowercase letters, digits, - or _ and can't be a built-in role")
+	ErrRoleAction     = errors.New("role contains an unknown or owner-only action")
+	ErrGrantToOwner   = errors.New("owners already have every permission")
+	ErrNoRoleToRevoke = errors.New("player has no role on this server")
+	ErrRoleTooHigh    = errors.New("you can only grant or revoke roles with permissions you have yourself")
+	roleNameRE        = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+)
+
+// RoleGrant gives a player (matched by Mojang UUID) a role on a server
+type RoleGrant struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// RolesView is a web view of the roles of a server
+type RolesView struct {
+	Builtin map[string][]string `json:"builtin"`
+	Custom  map[string][]string `json:"custom"`
+	Grants  []RoleGrant         `json:"grants"`
+	Actions map[string]string   `json:"actions"`
+}
+
+// PlayerRole returns the role a player has on the server, in-game ops without
+// a granted role are operators. Owners have no role, they can do everything.
+func (s *Server) PlayerRole(player string) string {
+	for _, g := range s.Grants {
+		if auth.SamePlayer(player, g.Name, g.UUID) {
+			return g.Role
+		}
+	}
+	if s.PlayerIsOp(player) {
+		return RoleOperator
+	}
+	return ""
+}
+
+// roleActions returns the actions of a built-in or custom role, custom roles saved
+// before an action became owner-only lose it here
+func (s *Server) roleActions(role string) ([]string, bool) {
+	if actions, ok := BuiltinRoles[role]; ok {
+		return actions, true
+	}
+	custom, ok := s.Roles[role]
+	var actions []string
+	for _, a := range custom {
+		if grantable(a) {
+			actions = append(actions, a)
+		}
+	}
+	return actions, ok
+}
+
+// canHandRole reports whether actor may grant or revoke a role with the given actions.
+// Owners and manager admins can hand out any role, everyone else only roles that can't
+// grant roles themselves and whose actions they have.
+func (s *Server) canHandRole(actor string, actions []string) bool {
+	if s.IsOwner(actor) || auth.IsAdmin(actor) {
+		return true
+	}
+	if inList("rol", actions) {
+		return false
+	}
+	var perms = s.PlayerPerms(actor)
+	for _, a := range actions {
+		if !perms[a].Allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// GrantRole gives a player a role on the server, replacing any role they had
+func (s *Server) GrantRole(actor, player, role string) error {
+	player = strings.TrimSpace(player)
+	actions, ok := s.roleActions(role)
+	if !ok {
+		return ErrUnknownRole
+	}
+	if !s.canHandRole(actor, actions) {
+		return ErrRoleTooHigh
+	}
+
+	uuid, err := auth.PlayerUUID(player)
+	if err != nil {
+		return ErrUnknownPlayer
+	}
+	if uuid == s.OwnerUUID || s.IsOwner(player) {
+		return ErrGrantToOwner
+	}
+	if current, _ := s.roleActions(s.PlayerRole(player)); !s.canHandRole(actor, current) {
+		return ErrRoleTooHigh
+	}
+
+	s.mu.Lock()
+	s.dropGrant(player, uuid)
+	s.Grants = append(s.Grants, RoleGrant{UUID: uuid, Name: player, Role: role})
+	s.mu.Unlock()
+	if err = s.SaveManagedJSON(); err != nil {
+		return err
+	}
+	storage.AuditWrite(actor, "role:grant", fmt.Sprintf("granted %s to %s (%s) on server %s", role, player, uuid, s.UUID))
+	return nil
+}
+
+// RevokeRole removes the role granted to a player
+func (s *Server) RevokeRole(actor, player string) error {
+	player = strings.TrimSpace(player)
+	if current, _ := s.roleActions(s.PlayerRole(player)); !s.canHandRole(actor, current) {
+		return ErrRoleTooHigh
+	}
+
+	s.mu.Lock()
+	removed := s.dropGrant(player, auth.KnownUUID(player))
+	s.mu.Unlock()
+	if !removed {
+		return ErrNoRoleToRevoke
+	}
+
+	if err := s.SaveManagedJSON(); err != nil {
+		return err
+	}
+	storage.AuditWrite(actor, "role:revoke", fmt.Sprintf("revoked the role of %s on server %s", player, s.UUID))
+	return nil
+}
+
+// DefineRole creates or replaces a custom role, no actions deletes it (and its grants)
+func (s *Server) DefineRole(actor, role string, actions []string) error {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if !roleNameRE.MatchString(role) || BuiltinRoles[role] != nil {
+		return ErrRoleName
+	}
+	for _, a := range actions {
+		if !grantable(a) {
+			return ErrRoleAction
+		}
+	}
+
+	var custom = make(map[string][]string)
+	for name, a := range s.Roles {
+		custom[name] = a
+	}
+
+	if len(actions) == 0 {
+		if _, ok := custom[role]; !ok {
+			return ErrUnknownRole
+		}
+		delete(custom, role)
+		var kept = []RoleGrant{}
+		for _, g := range s.Grants {
+			if g.Role != role {
+				kept = append(kept, g)
+			}
+		}
+		s.mu.Lock()
+		s.Grants = kept
+		s.mu.Unlock()
+		storage.AuditWrite(actor, "role:delete", fmt.Sprintf("deleted role %s on server %s", role, s.UUID))
+	} else {
+		custom[role] = actions
+		storage.AuditWrite(actor, "role:define", fmt.Sprintf("defined role %s as %s on server %s", role, strings.Join(actions, ","), s.UUID))
+	}
+
+	s.mu.Lock()
+	s.Roles = custom
+	s.mu.Unlock()
+	return s.SaveManagedJSON()
+}
+
+// RolesView returns the roles, grants and grantable actions of the server
+func (s *Server) RolesView() RolesView {
+	var rv = RolesView{
+		Builtin: BuiltinRoles,
+		Custom:  s.Roles,
+		Grants:  []RoleGrant{},
+		Actions: make(map[string]string),
+	}
+	if rv.Custom == nil {
+		rv.Custom = make(map[string][]string)
+	}
+
+	for _, g := range s.Grants {
+		if name := auth.KnownName(g.UUID); name != "" {
+			g.Name = name
+		}
+		rv.Grants = append(rv.Grants, g)
+	}
+	sort.Slice(rv.Grants, func(i, j int) bool {
+		return rv.Grants[i].Name < rv.Grants[j].Name
+	})
+
+	for key, p := range newPermissions() {
+		if grantable(key) {
+			rv.Actions[key] = p.Name
+		}
+	}
+	return rv
+}
+
+// HasRole returns if the player was granted a role on the server
+func (s *Server) HasRole(player string) bool {
+	for _, g := range s.Grants {
+		if auth.SamePlayer(player, g.Name, g.UUID) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropGrant removes the grant of a player matched by UUID or name, returns if one was removed.
+// Callers must hold s.mu.
+func (s *Server) dropGrant(player, uuid string) bool {
+	var kept = []RoleGrant{}
+	for _, g := range s.Grants {
+		if (uuid != "" && g.UUID == uuid) || auth.SamePlayer(player, g.Name, g.UUID) {
+			continue
+		}
+		kept = append(kept, g)
+	}
+	var removed = len(kept) != len(s.Grants)
+	s.Grants = kept
+	return removed
+}
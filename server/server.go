@@ -4,23 +4,31 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jlmeeker/mcmanager/auth"
+	"github.com/jlmeeker/mcmanager/events"
 	"github.com/jlmeeker/mcmanager/forms"
 	"github.com/jlmeeker/mcmanager/paper"
+	"github.com/jlmeeker/mcmanager/ping"
+	"github.com/jlmeeker/mcmanager/ports"
+	"github.com/jlmeeker/mcmanager/query"
 	"github.com/jlmeeker/mcmanager/rcon"
+	"github.com/jlmeeker/mcmanager/rconparse"
 	"github.com/jlmeeker/mcmanager/releases"
 	"github.com/jlmeeker/mcmanager/spigot"
 	"github.com/jlmeeker/mcmanager/storage"
@@ -36,6 +44,12 @@ var (
 	Java16 string
 )
 
+// Trash settings, deleted servers are purged after TrashRetention (0 keeps them forever)
+var (
+	TrashRetention time.Duration
+	TrashArchive   bool
+)
+
 // Hostname takes the flag value and calculates the best attempt at a hostname
 func Hostname(flagValue string) {
 	var hn string
@@ -51,79 +65,135 @@ func Hostname(flagValue string) {
 	HOSTNAME = hn
 }
 
-//AuthorizeMiddleware middleware
+// AuthorizeMiddleware middleware
 func AuthorizeMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		playerName, _ := c.Cookie("player")
+		playerName := c.GetString("player")
 		serverID := c.Param("serverid")
 		action := c.Param("action")
-		if s, ok := Servers[serverID]; ok {
-			if s.Deleted {
-				c.AbortWithStatus(http.StatusNotFound)
-				return
-			}
-			perms := s.PlayerPerms(playerName)
-			if perms[action].Allowed {
-				c.Next()
-				return
-			}
+		s, ok := Servers.Get(serverID)
+		if !ok || (s.Deleted && !inList(action, TrashActions)) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"result": http.StatusNotFound,
+				"error":  ErrNotFound.Error(),
+			})
+			return
+		}
+		if !s.PlayerPerms(playerName)[action].Allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"result": http.StatusForbidden,
+				"error":  "not allowed",
+			})
+			return
 		}
-		c.AbortWithStatus(http.StatusUnauthorized)
+		if t, ok := c.Get("apitoken"); ok && !t.(auth.APIToken).Allows(serverID, action) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"result": http.StatusForbidden,
+				"error":  "not allowed for this api token",
+			})
+			return
+		}
+		c.Next()
 	}
 }
 
-// Servers is the global list of managed servers
-var Servers = make(map[string]Server)
+// Servers is the global registry of managed servers
+var Servers = NewRegistry()
+
+// ErrNotFound is returned when a server ID isn't in the registry
+var ErrNotFound = errors.New("server not found")
+
+// ErrPartialPlayers is returned with the players of a server that only told some of them
+var ErrPartialPlayers = errors.New("server only listed some of its online players")
 
-// Server is an instance of a server, tracked during runtime
+// Server is an instance of a server, tracked during runtime.
+// mu guards the fields against the snapshots taken by Servers.Get and All,
+// code changing them holds it (and runs under Servers.Do once registered).
 type Server struct {
-	AutoStart bool       `json:"autostart"`
-	Deleted   bool       `json:"deleted"`
-	Flavor    string     `json:"flavor"`
-	MaxMem    string     `json:"maxmem"`
-	MinMem    string     `json:"minmem"`
-	Name      string     `json:"name"`
-	Owner     string     `json:"owner"`
-	Props     Properties `json:"properties"`
-	Release   string     `json:"release"`
-	UUID      string     `json:"uuid"`
+	AutoStart   bool                `json:"autostart"`
+	CoOwners    []CoOwner           `json:"coowners"`
+	Deleted     bool                `json:"deleted"`
+	DeletedAt   time.Time           `json:"deletedat"`
+	Description string              `json:"description"`
+	Flavor      string              `json:"flavor"`
+	Grants      []RoleGrant         `json:"grants"`
+	MaxMem      string              `json:"maxmem"`
+	MinMem      string              `json:"minmem"`
+	Name        string              `json:"name"`
+	Notes       string              `json:"notes"`
+	Owner       string              `json:"owner"`
+	OwnerUUID   string              `json:"owneruuid"`
+	Props       Properties          `json:"properties"`
+	Release     string              `json:"release"`
+	Roles       map[string][]string `json:"roles"`
+	Tags        []string            `json:"tags"`
+	UUID        string              `json:"uuid"`
+
+	reporter Reporter
+	mu       sync.RWMutex
+}
+
+// Reporter receives progress messages and command output from long-running operations
+type Reporter interface {
+	io.Writer
+	Report(text string)
 }
 
 // NewServer creates a new instance of Server, and sets up the serverdir
-func NewServer(owner string, formData forms.NewServer, port int) (Server, error) {
-	var s = Server{
-		Name:      formData.Name,
+// a port of 0 will allocate the next free port from the configured range
+func NewServer(owner string, formData forms.NewServer, port int, reporter Reporter) (*Server, error) {
+	var s = &Server{
+		Name:      strings.TrimSpace(formData.Name),
 		Owner:     owner,
 		Flavor:    formData.Flavor,
 		Release:   formData.Release,
 		AutoStart: formData.AutoStart,
+		MaxMem:    strings.ToUpper(strings.TrimSpace(formData.Memory)),
+		reporter:  reporter,
 	}
 
 	var err error
 	var suuid uuid.UUID
 	var pUUID string
+	var assigned ports.Assignment
 	for err == nil {
 		suuid, err = uuid.NewRandom()
 		s.UUID = suuid.String()
 
 		if !releases.FlavorIsValid(s.Flavor) {
 			err = errors.New("invalid flavor")
+			break
 		}
 
-		if port == 0 {
-			err = errors.New("unable to find an available port")
+		// hold the name until the server is registered below
+		nameMu.Lock()
+		err = validateName(owner, s.Name, "")
+		if err == nil {
+			defer reserveName(owner, s.Name)()
+		}
+		nameMu.Unlock()
+		if err != nil {
+			break
+		}
+
+		assigned, err = ports.Allocate(s.UUID, port)
+		if err != nil {
+			break
 		}
 
 		// attempt download first (no-op if it exists)
+		s.report(fmt.Sprintf("getting %s %s jar", s.Flavor, s.Release))
 		err = s.DownloadJar()
+		s.report("writing server files")
 		err = storage.MakeServerDir(s.UUID)
 		err = writeDefaultPropertiesFile(s.ServerDir())
 		err = s.RefreshProperties()
+		s.Props.set("enable-query", "true")
 		s.Props.set("enable-rcon", "true")
 		s.Props.set("gamemode", formData.GameMode)
 		s.Props.set("rcon.password", "admin")
 		s.Props.set("motd", formData.MOTD)
-		s.Props.setPort(port)
+		s.Props.setPorts(assigned)
 		s.Props.set("level-type", formData.WorldType)
 
 		if formData.Seed != "" {
@@ -146,20 +216,32 @@ func NewServer(owner string, formData forms.NewServer, port int) (Server, error)
 		err = acceptEULA(s.ServerDir())
 		err = storage.DeployJar(s.Flavor, s.Release, s.UUID)
 		err = s.SaveManagedJSON()
-		pUUID, err = auth.PlayerUUIDLookup(owner)
+		pUUID, err = auth.PlayerUUID(owner)
+		s.OwnerUUID = pUUID
+		err = s.SaveManagedJSON()
 		err = s.AddOpOffline(owner, pUUID, true)
 
 		if s.WhitelistEnabled() {
 			err = s.AddWhitelistOffline(owner, pUUID, true)
 		}
 
+		s.report("setting up backups")
 		err = storage.SetupServerBackup(s.UUID)
 		storage.AuditWrite(s.Owner, "create", fmt.Sprintf("created server %s", s.UUID))
 		break
 	}
 
 	if err == nil && formData.StartNow {
-		err = s.Start()
+		s.report("starting")
+	}
+	s.SetReporter(nil)
+
+	if err == nil {
+		Servers.Put(s)
+	}
+
+	if err == nil && formData.StartNow {
+		err = Servers.Do(s.UUID, (*Server).Start)
 	}
 
 	return s, err
@@ -167,21 +249,21 @@ func NewServer(owner string, formData forms.NewServer, port int) (Server, error)
 
 // loadManagedJSON reads in the managed.json file
 // Does NOT read in server.properties (will likely be stale)
-func loadManagedJSON(serverDir string) (Server, error) {
-	var s Server
+func loadManagedJSON(serverDir string) (*Server, error) {
+	var s = &Server{}
 	var err error
 	b, err := os.ReadFile(filepath.Join(serverDir, "managed.json"))
 	if err != nil {
 		return s, err
 	}
 
-	err = json.Unmarshal(b, &s)
+	err = json.Unmarshal(b, s)
 	return s, err
 }
 
 // LoadServer creates a new instance of Server from an existing serverdir
-func LoadServer(serverDir string) (Server, error) {
-	var s Server
+func LoadServer(serverDir string) (*Server, error) {
+	var s *Server
 	var err error
 
 	for err == nil {
@@ -190,13 +272,26 @@ func LoadServer(serverDir string) (Server, error) {
 		break
 	}
 
+	// servers from before owners were tracked by UUID
+	if s.OwnerUUID == "" {
+		s.OwnerUUID = auth.KnownUUID(s.Owner)
+	}
+
+	// servers deleted before the deletion time was tracked, managed.json is rewritten on
+	// every load so its mtime is the last startup, not the deletion. Start their retention
+	// now (it is saved below), they are purged a full retention period from today.
+	if s.Deleted && s.DeletedAt.IsZero() {
+		s.DeletedAt = time.Now()
+	}
+
 	// Save here to get new properties written to managed.json
 	return s, s.SaveManagedJSON()
 }
 
-// LoadServers loads servers from disk and caches results
+// LoadServers loads servers from disk into the registry
+// servers already registered are left untouched
 func LoadServers() error {
-	var servers = make(map[string]Server)
+	var servers = make(map[string]*Server)
 	var basedir = filepath.Join(storage.STORAGEDIR, "servers")
 	entries, err := os.ReadDir(basedir)
 	if err != nil {
@@ -216,7 +311,17 @@ func LoadServers() error {
 		}
 	}
 
-	Servers = servers
+	var assigned = make(map[string]ports.Assignment)
+	for id, s := range servers {
+		if !s.Deleted {
+			assigned[id] = s.Ports()
+		}
+	}
+	for _, err := range ports.Sync(assigned) {
+		log.Printf("port conflict: %s", err.Error())
+	}
+
+	Servers.sync(servers)
 	return nil
 }
 
@@ -258,7 +363,7 @@ func (s *Server) AddOpOnline(opName string) error {
 		return err
 	}
 
-	if _, err := s.rcon(fmt.Sprintf("op %s", opName)); err != nil {
+	if _, err := s.Rcon(fmt.Sprintf("op %s", opName)); err != nil {
 		return err
 	}
 
@@ -308,7 +413,7 @@ func (s *Server) AddWhitelistOnline(playerName string) error {
 		return err
 	}
 
-	if _, err := s.rcon(fmt.Sprintf("whitelist add %s", playerName)); err != nil {
+	if _, err := s.Rcon(fmt.Sprintf("whitelist add %s", playerName)); err != nil {
 		return err
 	}
 
@@ -322,12 +427,16 @@ func (s *Server) AddWhitelistOnline(playerName string) error {
 
 // Backup will instruct the server to perform a save-all operation
 func (s *Server) Backup(message string) error {
-	return storage.GitCommit(s.UUID, message)
+	err := storage.GitCommit(s.UUID, message)
+	if err == nil {
+		events.Publish(events.Event{Type: events.BackupCompleted, ServerID: s.UUID, Data: message})
+	}
+	return err
 }
 
 // Day will instruct the server to set the time to day
 func (s *Server) Day() error {
-	_, err := s.rcon("time set day")
+	_, err := s.Rcon("time set day")
 	if err != nil {
 		return err
 	}
@@ -341,12 +450,139 @@ func (s *Server) Delete() error {
 		return err
 	}
 
+	s.mu.Lock()
 	s.AutoStart = false
 	s.Deleted = true
+	s.DeletedAt = time.Now()
+	s.mu.Unlock()
 	s.SaveManagedJSON()
+	if err := ports.Release(s.UUID); err != nil {
+		log.Printf("unable to release ports of %s: %s", s.UUID, err.Error())
+	}
 	return s.Backup("deleted")
 }
 
+// CrashCount returns the number of crash reports the server has written
+func (s *Server) CrashCount() int {
+	entries, err := os.ReadDir(filepath.Join(s.ServerDir(), "crash-reports"))
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// Undelete restores a server from the trash, re-assigning its ports (new ones if they've been taken)
+func (s *Server) Undelete() error {
+	if !s.Deleted {
+		return nil
+	}
+
+	a, err := ports.Allocate(s.UUID, s.Ports().Game)
+	if err != nil {
+		log.Printf("previous ports of %s unavailable (%s), allocating new ones", s.UUID, err.Error())
+		a, err = ports.Allocate(s.UUID, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if a != s.Ports() {
+		s.mu.Lock()
+		s.Props.setPorts(a)
+		s.mu.Unlock()
+		if err = s.SaveProps(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.Deleted = false
+	s.DeletedAt = time.Time{}
+	s.mu.Unlock()
+	if err = s.SaveManagedJSON(); err != nil {
+		return err
+	}
+	return s.Backup("undeleted")
+}
+
+// Purge permanently removes a deleted server from disk, optionally archiving it first
+func (s *Server) Purge(archive bool) error {
+	if !s.Deleted {
+		return errors.New("only deleted servers can be purged")
+	}
+
+	if archive {
+		path, err := storage.ArchiveServer(s.UUID)
+		if err != nil {
+			return fmt.Errorf("archive failed, not purging: %s", err.Error())
+		}
+		log.Printf("archived %s to %s", s.UUID, path)
+	}
+
+	if err := storage.DeleteServer(s.UUID); err != nil {
+		return err
+	}
+
+	ports.Release(s.UUID)
+	Servers.Remove(s.UUID)
+	storage.AuditWrite(s.Owner, "purge", fmt.Sprintf("purged server %s (%s)", s.UUID, s.Name))
+	return nil
+}
+
+// PurgeExpired purges servers that have been in the trash longer than TrashRetention
+func PurgeExpired() {
+	if TrashRetention <= 0 {
+		return
+	}
+
+	for _, s := range Servers.All() {
+		if !s.Deleted || s.DeletedAt.IsZero() || time.Since(s.DeletedAt) < TrashRetention {
+			continue
+		}
+
+		err := Servers.Do(s.UUID, func(s *Server) error {
+			return s.Purge(TrashArchive)
+		})
+		if err != nil {
+			log.Printf("unable to purge %s: %s", s.UUID, err.Error())
+		}
+	}
+}
+
+// TrashView is a web view of a deleted server
+type TrashView struct {
+	Name      string     `json:"name"`
+	UUID      string     `json:"uuid"`
+	Flavor    string     `json:"flavor"`
+	Release   string     `json:"release"`
+	DeletedAt time.Time  `json:"deletedat"`
+	PurgeAt   *time.Time `json:"purgeat,omitempty"`
+}
+
+// Trash returns the deleted servers owned by a player
+func Trash(playerName string) []TrashView {
+	var result = []TrashView{}
+	for _, s := range Servers.All() {
+		if !s.Deleted || !s.IsOwner(playerName) {
+			continue
+		}
+
+		tv := TrashView{
+			Name:      s.Name,
+			UUID:      s.UUID,
+			Flavor:    s.Flavor,
+			Release:   s.Release,
+			DeletedAt: s.DeletedAt,
+		}
+		if TrashRetention > 0 && !s.DeletedAt.IsZero() {
+			purgeAt := s.DeletedAt.Add(TrashRetention)
+			tv.PurgeAt = &purgeAt
+		}
+		result = append(result, tv)
+	}
+	return result
+}
+
 // DownloadJar downloads the server jar
 func (s *Server) DownloadJar() error {
 	var err error
@@ -356,7 +592,8 @@ func (s *Server) DownloadJar() error {
 		case "vanilla":
 			err = vanilla.DownloadReleases([]string{s.Release})
 		case "spigot":
-			err = spigot.Build(s.Release)
+			s.report("building spigot " + s.Release + ", this could take a while")
+			err = spigot.Build(s.Release, s.output())
 		case "paper":
 			err = paper.DownloadReleases([]string{s.Release})
 		}
@@ -375,20 +612,23 @@ func (s *Server) IsOp(player string) bool {
 	return false
 }
 
-// IsOwner returns if a given player name the server owner
+// IsOwner returns if a given player is the server owner or one of its co-owners
 func (s *Server) IsOwner(player string) bool {
-	if player == s.Owner {
-		return true
-	}
-	return false
+	return s.IsPrimaryOwner(player) || s.IsCoOwner(player)
 }
 
 // IsRunning attempts to determine if the server is running by checking rcon connect
+// falling back to the game port for servers without rcon enabled
 func (s *Server) IsRunning() bool {
-	conn, err := net.Dial("tcp", "localhost:"+s.Props["rcon.port"])
-	if err == nil {
-		conn.Close()
-		return true
+	for _, port := range []string{s.Props["rcon.port"], s.Props["server-port"]} {
+		if port == "" {
+			continue
+		}
+		conn, err := net.Dial("tcp", "localhost:"+port)
+		if err == nil {
+			conn.Close()
+			return true
+		}
 	}
 	return false
 }
@@ -458,43 +698,95 @@ func (s *Server) PlayerIsWhitelisted(playerName string) bool {
 func (s *Server) PlayerPerms(playerName string) Permissions {
 	var perms = PermissionsPlayer()
 
-	for _, op := range s.Ops() {
-		if op.Name == playerName {
-			perms = PermissionsOp()
-			break
-		}
+	if role := s.PlayerRole(playerName); role != "" {
+		actions, _ := s.roleActions(role)
+		perms = PermissionsRole(actions)
 	}
 
-	if playerName == s.Owner {
+	if s.IsPrimaryOwner(playerName) || auth.IsAdmin(playerName) {
+		perms = allowPerms(PrimaryOwnerActions, PermissionsOwner())
+	} else if s.IsCoOwner(playerName) {
 		perms = PermissionsOwner()
 	}
 
 	return perms
 }
 
-// Players gets player list
-func (s *Server) Players() []string {
-	var players []string
-	reply, err := s.rcon("list")
+// Ping queries the server status using the Server List Ping protocol (no rcon needed)
+func (s *Server) Ping() (ping.Status, error) {
+	port, err := strconv.Atoi(s.Props.get("server-port"))
 	if err != nil {
-		return players
+		return ping.Status{}, err
 	}
+	return ping.Ping("localhost", port, 0)
+}
 
-	parts := strings.Split(reply, ":")
-	if len(parts) < 2 {
-		return players
+// Query requests the full stat from the server's query port (requires enable-query)
+func (s *Server) Query() (query.FullStat, error) {
+	if s.Props.get("enable-query") != "true" {
+		return query.FullStat{}, errors.New("query not enabled")
 	}
 
-	players = strings.Split(strings.TrimSpace(parts[1]), ",")
+	port, err := strconv.Atoi(s.Props.get("query.port"))
+	if err != nil {
+		return query.FullStat{}, err
+	}
+	return query.Full("localhost", port, 0)
+}
 
+// Ports returns the ports the server is configured with
+func (s *Server) Ports() ports.Assignment {
+	var a ports.Assignment
+	a.Game, _ = strconv.Atoi(s.Props.get("server-port"))
+	a.Query, _ = strconv.Atoi(s.Props.get("query.port"))
+	a.Rcon, _ = strconv.Atoi(s.Props.get("rcon.port"))
+	return a
+}
+
+// Players gets player list
+// prefers the query protocol (complete list), then rcon, then the status ping sample
+func (s *Server) Players() []string {
+	players, _ := s.OnlinePlayers()
 	return players
 }
 
+// OnlinePlayers is Players with an error when the server couldn't be asked at all,
+// or ErrPartialPlayers when the status ping sample is all there is and it is incomplete
+func (s *Server) OnlinePlayers() ([]string, error) {
+	if fs, err := s.Query(); err == nil {
+		return fs.Players, nil
+	}
+
+	reply, err := s.Rcon("list")
+	if err != nil {
+		st, perr := s.Ping()
+		if perr != nil {
+			return nil, perr
+		}
+		// the sample is a handful of players the server picks (or makes up)
+		names := st.SampleNames()
+		if len(names) < st.Players.Online {
+			return names, ErrPartialPlayers
+		}
+		return names, nil
+	}
+
+	pl, err := rconparse.List(reply)
+	if err != nil {
+		log.Printf("unable to parse player list from %s: %s", s.UUID, err.Error())
+		return nil, err
+	}
+
+	return pl.Players, nil
+}
+
 // RefreshProperties reads in the server.properties values
 func (s *Server) RefreshProperties() error {
 	p, err := loadProperties(s.ServerDir())
 	if err == nil {
+		s.mu.Lock()
 		s.Props = p
+		s.mu.Unlock()
 	}
 	return err
 }
@@ -505,18 +797,20 @@ func (s *Server) Regen() error {
 	var running = s.IsRunning()
 	for err == nil {
 		if running {
+			s.report("stopping")
 			err = s.Stop(0)
 		}
 
+		s.report("erasing world")
 		err = storage.EraseServerFile(s.UUID, "logs")
 		err = storage.EraseServerFile(s.UUID, "world")
 		err = storage.EraseServerFile(s.UUID, "world_nether")
 		err = storage.EraseServerFile(s.UUID, "world_the_end")
 		err = storage.EraseServerFile(s.UUID, ".git")
 		err = storage.SetupServerBackup(s.UUID)
-		err = LoadServers()
 
 		if running {
+			s.report("starting")
 			err = s.Start()
 		}
 		break
@@ -526,14 +820,14 @@ func (s *Server) Regen() error {
 }
 
 // Rcon sends a message to the server's rcon
-func (s *Server) rcon(msg string) (string, error) {
+func (s *Server) Rcon(msg string) (string, error) {
 	//fmt.Printf("server send rcon: %s\n", msg)
 	return rcon.Send(msg, s.Props["rcon.port"], s.Props["rcon.password"])
 }
 
 // Save will instruct the server to perform a save-all operation
 func (s *Server) Save() error {
-	_, err := s.rcon("save-all")
+	_, err := s.Rcon("save-all")
 	if err != nil {
 		return err
 	}
@@ -575,22 +869,53 @@ func (s *Server) SaveWhitelist(wlps []WLPlayer) error {
 	return os.WriteFile(filepath.Join(s.ServerDir(), "whitelist.json"), b, 0640)
 }
 
+// SetReporter sets (or clears with nil) where progress of long-running operations is sent
+// callers should hold the server's lifecycle lock (see Registry.Do)
+func (s *Server) SetReporter(r Reporter) {
+	s.reporter = r
+}
+
+// report sends a progress message to the reporter, if any
+func (s *Server) report(text string) {
+	if s.reporter != nil {
+		s.reporter.Report(text)
+	}
+}
+
+// output is where command output of long-running operations should go
+func (s *Server) output() io.Writer {
+	if s.reporter != nil {
+		return s.reporter
+	}
+	return io.Discard
+}
+
 // ServerDir builds the path to the server storage dir
 func (s *Server) ServerDir() string {
 	return filepath.Join(storage.SERVERDIR, s.UUID)
 }
 
 // Start starts the server (expected to be run as a goroutine)
-func (s Server) Start() error {
+func (s *Server) Start() error {
 	if s.IsRunning() {
 		return errors.New("server already running")
 	}
 
-	if s.MaxMem == "" {
-		s.MaxMem = "6G"
+	// pick up server.properties changes made while it was stopped
+	if err := s.RefreshProperties(); err != nil {
+		log.Printf("unable to read server.properties of %s: %s", s.UUID, err.Error())
+	}
+
+	var maxMem, minMem = s.MaxMem, s.MinMem
+	if maxMem == "" {
+		maxMem = DefaultMemory
 	}
-	if s.MinMem == "" {
-		s.MinMem = s.MaxMem
+	if minMem == "" {
+		minMem = maxMem
+	}
+
+	if err := admit(s); err != nil {
+		return err
 	}
 
 	// Minecraft v1.17 started requiring Java 16 so we set that as the default version
@@ -600,8 +925,8 @@ func (s Server) Start() error {
 	}
 
 	var args = []string{
-		"-Xms" + s.MinMem,
-		"-Xmx" + s.MaxMem,
+		"-Xms" + minMem,
+		"-Xmx" + maxMem,
 		"-XX:+UseG1GC",
 		"-XX:+ParallelRefProcEnabled",
 		"-XX:MaxGCPauseMillis=200",
@@ -633,9 +958,11 @@ func (s Server) Start() error {
 	}
 	err := cmd.Start()
 	if err != nil {
+		release(s.UUID)
 		return err
 	}
 
+	events.Publish(events.Event{Type: events.ServerStarted, ServerID: s.UUID})
 	return cmd.Process.Release()
 }
 
@@ -645,19 +972,22 @@ func (s *Server) Stop(delay int) error {
 		return nil
 	}
 
-	if _, err := s.rcon(fmt.Sprintf("/say Server shutting down in %d seconds", delay)); err != nil {
+	if _, err := s.Rcon(fmt.Sprintf("/say Server shutting down in %d seconds", delay)); err != nil {
 		return err
 	}
 
 	time.Sleep(time.Duration(delay) * time.Second)
 
-	if _, err := s.rcon("stop"); err != nil {
+	if _, err := s.Rcon("stop"); err != nil {
 		return err
 	}
 
 	for s.IsRunning() {
 		time.Sleep(1 * time.Second)
 	}
+	release(s.UUID)
+
+	events.Publish(events.Event{Type: events.ServerStopped, ServerID: s.UUID})
 	return nil
 }
 
@@ -667,29 +997,34 @@ func (s *Server) Upgrade() error {
 	var err error
 
 	if wasRunning {
+		s.report("stopping")
 		err = s.Stop(0)
 		if err != nil {
 			return err
 		}
 	}
 
+	s.report("backing up")
 	err = s.Backup("pre upgrade")
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
 	switch s.Flavor {
 	case "vanilla", "spigot":
 		s.Release = vanilla.Releases.Latest.Release
 	case "paper":
 		s.Release = paper.Releases.Latest.Release
 	}
+	s.mu.Unlock()
 
 	err = s.SaveManagedJSON()
 	if err != nil {
 		return err
 	}
 
+	s.report(fmt.Sprintf("getting %s %s jar", s.Flavor, s.Release))
 	err = s.DownloadJar()
 	if err != nil {
 		return err
@@ -706,6 +1041,7 @@ func (s *Server) Upgrade() error {
 	}
 
 	if wasRunning {
+		s.report("starting")
 		err = s.Start()
 	}
 
@@ -714,37 +1050,79 @@ func (s *Server) Upgrade() error {
 
 // WebView returns a web-formatted view of the server
 func (s *Server) WebView(playerName string) WebView {
+	return s.PlayerView(s.StatusView(), playerName)
+}
+
+// PlayerView fills in the parts of a status view that depend on who is looking
+func (s *Server) PlayerView(wv WebView, playerName string) WebView {
+	wv.Permissions = s.PlayerPerms(playerName)
+	wv.Role = s.PlayerRole(playerName)
+
+	// notes are for the owner only
+	if s.IsOwner(playerName) {
+		wv.Notes = s.Notes
+		wv.Role = "owner"
+	}
+	return wv
+}
+
+// StatusView is the web view of the server without the player's permissions, role
+// and notes. It asks the server (ping, query and rcon) so it can be shared between players.
+func (s *Server) StatusView() WebView {
 	var ops []string
 	for _, op := range s.Ops() {
 		ops = append(ops, op.Name)
 	}
-	s.RefreshProperties()
-	return WebView{
+	var wv = WebView{
 		AutoStart:        s.AutoStart,
+		Description:      s.Description,
 		Flavor:           s.Flavor,
 		GameMode:         s.Props.get("gamemode"),
 		Hardcore:         s.Props.get("hardcore"),
 		MOTD:             s.Props.get("motd"),
 		Name:             s.Name,
 		Ops:              strings.Join(ops, ", "),
-		Owner:            s.Owner,
-		Permissions:      s.PlayerPerms(playerName),
+		CoOwners:         s.CoOwnerNames(),
+		Owner:            s.OwnerName(),
 		Players:          s.Players(),
 		PVP:              s.Props.get("pvp"),
 		Port:             s.Props.get("server-port"),
 		Release:          s.Release,
 		Running:          s.IsRunning(),
 		Seed:             s.Props.get("level-seed"),
+		Tags:             s.Tags,
 		UUID:             s.UUID,
 		WhiteListEnabled: s.WhitelistEnabled(),
 		WhiteList:        s.Whitelist(),
 		WorldType:        s.Props.get("level-type"),
 	}
+
+	if wv.Tags == nil {
+		wv.Tags = []string{}
+	}
+
+	if st, err := s.Ping(); err == nil {
+		wv.Running = true
+		wv.Version = st.Version.Name
+		wv.MOTD = st.MOTD()
+		wv.Online = st.Players.Online
+		wv.MaxPlayers = st.Players.Max
+		wv.Sample = st.SampleNames()
+		wv.Latency = st.Latency.Milliseconds()
+	}
+
+	if fs, err := s.Query(); err == nil {
+		wv.Map = fs.Map
+		wv.Plugins = fs.Plugins
+		wv.Software = fs.Software
+	}
+
+	return wv
 }
 
 // WeatherClear will instruct the server to perform a save-all operation
 func (s *Server) WeatherClear() error {
-	_, err := s.rcon("weather clear")
+	_, err := s.Rcon("weather clear")
 	if err != nil {
 		return err
 	}
@@ -753,17 +1131,17 @@ func (s *Server) WeatherClear() error {
 
 // Whitelist returns the list of whitelisted players
 func (s *Server) Whitelist() string {
-	reply, err := s.rcon("whitelist list")
+	reply, err := s.Rcon("whitelist list")
 	if err != nil {
 		return ""
 	}
 
-	parts := strings.Split(reply, ":")
-	if len(parts) < 2 {
+	wlps, err := rconparse.Whitelist(reply)
+	if err != nil {
 		return ""
 	}
 
-	return parts[1]
+	return strings.Join(wlps, ", ")
 }
 
 // WhitelistEnabled will instruct the server to whitelist a player
@@ -774,80 +1152,99 @@ func (s *Server) WhitelistEnabled() bool {
 // WebView web view of a server instance
 type WebView struct {
 	AutoStart        bool        `json:"autostart"`
+	CoOwners         []string    `json:"coowners"`
+	Description      string      `json:"description"`
 	Flavor           string      `json:"flavor"`
 	GameMode         string      `json:"gamemode"`
 	Hardcore         string      `json:"hardcore"`
+	Latency          int64       `json:"latency"`
+	Map              string      `json:"map"`
+	MaxPlayers       int         `json:"maxplayers"`
 	MOTD             string      `json:"motd"`
 	Name             string      `json:"name"`
+	Notes            string      `json:"notes"`
+	Online           int         `json:"online"`
 	Ops              string      `json:"ops"`
 	Owner            string      `json:"owner"`
 	Permissions      Permissions `json:"perms"`
+	Plugins          []string    `json:"plugins"`
 	Players          []string    `json:"players"`
 	Port             string      `json:"port"`
 	PVP              string      `json:"pvp"`
 	Release          string      `json:"release"`
+	Role             string      `json:"role"`
 	Running          bool        `json:"running"`
+	Sample           []string    `json:"sample"`
 	Seed             string      `json:"seed"`
+	Software         string      `json:"software"`
+	Tags             []string    `json:"tags"`
 	UUID             string      `json:"uuid"`
+	Version          string      `json:"version"`
 	WhiteList        string      `json:"whitelist"`
 	WhiteListEnabled bool        `json:"whitelistenabled"`
 	WorldType        string      `json:"worldtype"`
 }
 
-// ServersWebView is a web view of a list of servers
+// ServersWebView is a web view of a list of servers, keyed by UUID
 func ServersWebView(playerName string) map[string]WebView {
 	var result = make(map[string]WebView)
-	for _, s := range ServersWithPlayer(playerName) {
-		result[s.Name] = s.WebView(playerName)
+	for id, s := range ServersWithPlayer(playerName) {
+		result[id] = s.WebView(playerName)
 	}
 
 	return result
 }
 
-//ServersWithPlayer returns a list of servers the Op owns or is an op on
-func ServersWithPlayer(playerName string) map[string]Server {
-	var servers = make(map[string]Server)
-
-	for n, s := range Servers {
-		if !s.Deleted && (s.IsOwner(playerName) || s.IsOp(playerName) || s.PlayerIsWhitelisted(playerName)) {
-			servers[n] = s
-		}
-	}
-
-	return servers
+// AdminView is an admin's view of a server, deleted or not
+type AdminView struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	CoOwners  []string  `json:"coowners"`
+	Flavor    string    `json:"flavor"`
+	Release   string    `json:"release"`
+	Port      string    `json:"port"`
+	Running   bool      `json:"running"`
+	Deleted   bool      `json:"deleted"`
+	DeletedAt time.Time `json:"deletedat"`
 }
 
-// NextAvailablePort will return the next available server port
-// one should loadServers() before this to ensure accurate data
-func NextAvailablePort() int {
-	var err error
-	var highest = 25564 // one less than the default server port (we increment it on return)
+// AllServers returns a view of every server, for admins
+func AllServers() []AdminView {
+	var result = []AdminView{}
+	for _, s := range Servers.All() {
+		result = append(result, AdminView{
+			UUID:      s.UUID,
+			Name:      s.Name,
+			Owner:     s.OwnerName(),
+			CoOwners:  s.CoOwnerNames(),
+			Flavor:    s.Flavor,
+			Release:   s.Release,
+			Port:      s.Props.get("server-port"),
+			Running:   !s.Deleted && s.IsRunning(),
+			Deleted:   s.Deleted,
+			DeletedAt: s.DeletedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
 
-	for err == nil {
-		// loop over servers and find highest used port number
-		err = LoadServers() // get current server data
+// ServersWithPlayer returns a list of servers the player owns, has a role on, is an op on or is whitelisted on
+// admins get every (non-deleted) server
+func ServersWithPlayer(playerName string) map[string]*Server {
+	var servers = make(map[string]*Server)
+	var admin = auth.IsAdmin(playerName)
 
-		for _, s := range Servers {
-			port := s.Props.get("server-port")
-			if port == "" {
-				continue
-			}
-			var portInt int
-			portInt, err = strconv.Atoi(port)
-			if portInt > highest {
-				highest = portInt
-			}
+	for _, s := range Servers.All() {
+		if !s.Deleted && (admin || s.IsOwner(playerName) || s.HasRole(playerName) || s.IsOp(playerName) || s.PlayerIsWhitelisted(playerName)) {
+			servers[s.UUID] = s
 		}
-		break
 	}
 
-	if err != nil {
-		fmt.Println(err.Error())
-		return 0
-	}
-
-	// rcon port is 10k less than the server port
-	return highest + 1
+	return servers
 }
 
 func inList(needle string, haystack []string) bool {
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/jlmeeker/mcmanager/ports"
 )
 
 // Properties is a hash of key:value pairs contained in the server.properties file
@@ -71,10 +73,10 @@ func (sp Properties) set(key, value string) {
 	sp[key] = value
 }
 
-func (sp *Properties) setPort(port int) {
-	sp.set("server-port", fmt.Sprintf("%d", port))
-	sp.set("query.port", fmt.Sprintf("%d", port))
-	sp.set("rcon.port", fmt.Sprintf("%d", port-10000))
+func (sp *Properties) setPorts(a ports.Assignment) {
+	sp.set("server-port", fmt.Sprintf("%d", a.Game))
+	sp.set("query.port", fmt.Sprintf("%d", a.Query))
+	sp.set("rcon.port", fmt.Sprintf("%d", a.Rcon))
 }
 
 func (sp Properties) get(key string) string {
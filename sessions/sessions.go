@@ -0,0 +1,271 @@
+package sessions
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/events"
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// Session is a single play session of a player on a server
+// Left is zero while the player is still online, Seen is the last poll that saw them
+// (on disk it can be up to seenEvery behind)
+type Session struct {
+	Player string    `json:"player"`
+	Joined time.Time `json:"joined"`
+	Seen   time.Time `json:"seen"`
+	Left   time.Time `json:"left"`
+}
+
+// lastSeen is when a player was last known to be online in a session
+func (ses Session) lastSeen() time.Time {
+	if ses.Seen.IsZero() {
+		return ses.Joined
+	}
+	return ses.Seen
+}
+
+// History is the session history of a server as stored on disk, closed sessions
+// are kept for keepFor and at most maxSessions of them
+type History struct {
+	ServerID string    `json:"serverid"`
+	Sessions []Session `json:"sessions"`
+	Peak     int       `json:"peak"`
+	PeakAt   time.Time `json:"peakat"`
+}
+
+// PlayerStats is the per-player summary of a server's session history
+type PlayerStats struct {
+	Player   string    `json:"player"`
+	Online   bool      `json:"online"`
+	Playtime int64     `json:"playtime"` // seconds
+	Sessions int       `json:"sessions"`
+	LastSeen time.Time `json:"lastseen"`
+}
+
+// seenEvery limits how often a poll that only moves Seen forward rewrites the history file,
+// joins, leaves and Flush always write it
+const seenEvery = 5 * time.Minute
+
+// History limits, older closed sessions are dropped when a history is saved
+const (
+	keepFor     = 365 * 24 * time.Hour
+	maxSessions = 5000
+)
+
+var (
+	histories = make(map[string]*History)
+	tracked   = make(map[string]bool)      // servers updated since the manager started
+	saved     = make(map[string]time.Time) // when each history was last written
+	dirty     = make(map[string]bool)      // histories with Seen changes not written yet
+	mu        sync.Mutex
+)
+
+// Track polls all servers for online players on the given interval (expected to be run as a goroutine)
+func Track(interval time.Duration) {
+	for {
+		for _, s := range server.Servers.All() {
+			id := s.UUID
+			if s.Deleted {
+				continue
+			}
+
+			var players []string
+			var reachable bool
+			if s.IsRunning() {
+				var err error
+				players, err = s.OnlinePlayers()
+				if err == server.ErrPartialPlayers {
+					// who joined or left can't be told from part of the list, wait for a full one
+					continue
+				}
+				reachable = err == nil
+			}
+
+			err := update(id, players, reachable, time.Now())
+			if err != nil {
+				log.Printf("sessions: unable to update %s: %s", id, err.Error())
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// update opens sessions for players that joined and closes sessions for players that left.
+// Sessions left open by an earlier run, or on a server that is down or didn't answer,
+// are closed when their player was last seen so the time in between isn't counted.
+func update(serverID string, players []string, reachable bool, now time.Time) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	h, err := load(serverID)
+	if err != nil {
+		return err
+	}
+
+	var online = make(map[string]bool)
+	for _, p := range players {
+		if p != "" {
+			online[p] = true
+		}
+	}
+
+	var resumed = !tracked[serverID]
+	tracked[serverID] = true
+
+	var changed bool
+	var open = make(map[string]bool)
+	for ndx, ses := range h.Sessions {
+		if !ses.Left.IsZero() {
+			continue
+		}
+		if online[ses.Player] && !resumed {
+			open[ses.Player] = true
+			h.Sessions[ndx].Seen = now
+			dirty[serverID] = true
+			continue
+		}
+
+		var left = now
+		if resumed || !reachable {
+			left = ses.lastSeen()
+		}
+		h.Sessions[ndx].Left = left
+		changed = true
+		events.Publish(events.Event{Type: events.PlayerLeft, ServerID: serverID, Player: ses.Player, Time: left})
+	}
+
+	for p := range online {
+		if !open[p] {
+			h.Sessions = append(h.Sessions, Session{Player: p, Joined: now, Seen: now})
+			changed = true
+			events.Publish(events.Event{Type: events.PlayerJoined, ServerID: serverID, Player: p, Time: now})
+		}
+	}
+
+	if len(online) > h.Peak {
+		h.Peak = len(online)
+		h.PeakAt = now
+		changed = true
+	}
+
+	if !changed && !(dirty[serverID] && now.Sub(saved[serverID]) >= seenEvery) {
+		return nil
+	}
+	return save(h, now)
+}
+
+// Flush writes the histories with unsaved Seen times, for when the manager exits
+func Flush() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for id, h := range histories {
+		if dirty[id] {
+			if err := save(h, time.Now()); err != nil {
+				log.Printf("sessions: unable to save %s: %s", id, err.Error())
+			}
+		}
+	}
+}
+
+// prune drops closed sessions older than keepFor and the oldest ones past maxSessions
+func prune(h *History, now time.Time) {
+	var kept = h.Sessions[:0]
+	for _, ses := range h.Sessions {
+		if ses.Left.IsZero() || now.Sub(ses.Left) < keepFor {
+			kept = append(kept, ses)
+		}
+	}
+	if extra := len(kept) - maxSessions; extra > 0 {
+		kept = kept[extra:]
+	}
+	h.Sessions = kept
+}
+
+// Stats returns the per-player summary for a server, most recently seen first
+func Stats(serverID string) ([]PlayerStats, History, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var result = []PlayerStats{}
+	h, err := load(serverID)
+	if err != nil {
+		return result, History{}, err
+	}
+
+	var now = time.Now()
+	var byPlayer = make(map[string]*PlayerStats)
+	for _, ses := range h.Sessions {
+		ps, ok := byPlayer[ses.Player]
+		if !ok {
+			ps = &PlayerStats{Player: ses.Player}
+			byPlayer[ses.Player] = ps
+		}
+
+		var end = ses.Left
+		if end.IsZero() {
+			end = now
+			ps.Online = true
+		}
+
+		ps.Sessions++
+		ps.Playtime += int64(end.Sub(ses.Joined).Seconds())
+		if end.After(ps.LastSeen) {
+			ps.LastSeen = end
+		}
+	}
+
+	for _, ps := range byPlayer {
+		result = append(result, *ps)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+
+	return result, *h, nil
+}
+
+// load returns the cached history, reading it from disk the first time (caller must hold mu)
+func load(serverID string) (*History, error) {
+	if h, ok := histories[serverID]; ok {
+		return h, nil
+	}
+
+	var h = &History{ServerID: serverID}
+	b, err := os.ReadFile(historyFile(serverID))
+	if err == nil {
+		err = json.Unmarshal(b, h)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return h, err
+	}
+
+	histories[serverID] = h
+	return h, nil
+}
+
+// save prunes a history and writes it to disk (caller must hold mu)
+func save(h *History, now time.Time) error {
+	prune(h, now)
+	saved[h.ServerID] = now
+	dirty[h.ServerID] = false
+
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(historyFile(h.ServerID), b, storage.DEFAULTFILEPERM)
+}
+
+func historyFile(serverID string) string {
+	return filepath.Join(storage.SESSIONDIR, serverID+".json")
+}
@@ -1,3 +1,19 @@
+// CSRF: every request that changes something carries the session's token from the csrf cookie
+function csrfToken() {
+  var match = document.cookie.match(/(?:^|;\s*)csrf=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : "";
+}
+
+(function () {
+  var open = XMLHttpRequest.prototype.open;
+  XMLHttpRequest.prototype.open = function (method) {
+    open.apply(this, arguments);
+    if (method.toUpperCase() != "GET") {
+      this.setRequestHeader("X-CSRF-Token", csrfToken());
+    }
+  };
+})();
+
 // Server Actions
 function addOp(serverID) {
   var opname = prompt("Player name of the new Op:");
@@ -16,15 +32,130 @@ function saveServer(id) {
   serverAction(id, "sav");
 }
 
-function deleteServer(name, id) {
-  var r = confirm("Delete " + name + "?\n\nTHIS CANNOT BE UNDONE !!!");
+function cloneServer(id) {
+  var name = serverCache[id].name;
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var backups = [];
+      if (this.status == 200) {
+        backups = JSON.parse(this.responseText).backups;
+      }
+      promptClone(name, id, backups);
+    }
+  };
+  xhttp.open("GET", "/api/v1/server/" + id + "/backups", true);
+  xhttp.send();
+}
+
+function promptClone(name, id, backups) {
+  var cloneName = prompt("Name of the clone of " + name + ":", name + " (copy)");
+  if (cloneName === null) {
+    return false;
+  }
+
+  var choices = backups.slice(0, 10).map(function (b) {
+    return b.commit + "  " + new Date(b.time * 1000).toLocaleString() + "  " + b.message;
+  });
+  var commit = prompt("Backup to clone from (leave empty for the current files):\n\n" + choices.join("\n"), "");
+  if (commit === null) {
+    return false;
+  }
+
+  var data = new FormData();
+  data.append("name", cloneName);
+  data.append("commit", commit.trim().split(" ")[0]);
+  serverAction(id, "cln", data);
+}
+
+// servers as last fetched, keyed by uuid
+var serverCache = {};
+
+function editServer(id) {
+  var item = serverCache[id];
+  document.getElementById("edit_uuid").value = id;
+  document.getElementById("edit_name").value = item.name;
+  document.getElementById("edit_description").value = item.description;
+  document.getElementById("edit_tags").value = item.tags.join(", ");
+  // notes are the owner's, other editors don't see (or change) them
+  document.getElementById("edit_notes").value = item.notes;
+  document.getElementById("edit_notes_group").classList.toggle("d-none", item.role != "owner");
+  new bootstrap.Modal(document.getElementById("editServerModal")).show();
+}
+
+function submitEdit(form) {
+  var data = new FormData(form);
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Saved";
+        toastList[0].show(); // successToast
+        closeModal('editServerModal');
+        fetchServers();
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+    }
+  };
+  xhttp.open("POST", "/api/v1/server/" + form.uuid.value + "/edt", true);
+  xhttp.send(data);
+  return false;
+}
+
+function deleteServer(id) {
+  var name = serverCache[id].name;
+  var r = confirm("Delete " + name + "?\n\nIt will be moved to the trash.");
   if (r === false) {
     return false;
   }
   serverAction(id, "del");
 }
 
-function regenServer(name, id) {
+function undeleteServer(id) {
+  trashAction(id, "und");
+}
+
+function purgeServer(name, id) {
+  var confirmName = prompt("Permanently purge " + name + "?\n\nTHIS CANNOT BE UNDONE !!!\n\nType the server name to confirm:");
+  if (confirmName === null) {
+    return false;
+  }
+  var data = new FormData();
+  data.append("confirm", confirmName);
+  trashAction(id, "prg", data);
+}
+
+function trashAction(id, action, formdata) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Action successful";
+        toastList[0].show(); // successToast
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+      if (document.getElementById("trash") !== null) {
+        fetchTrash();
+      }
+    }
+  };
+  xhttp.open("POST", "/api/v1/server/" + id + "/" + action, true);
+
+  if (formdata instanceof Object) {
+    xhttp.send(formdata);
+  } else {
+    xhttp.send();
+  }
+}
+
+function regenServer(id) {
+  var name = serverCache[id].name;
   var r = confirm("Regen " + name + "?\n\nTHIS WILL DELETE ALL WORLD AND IN-GAME PLAYER DATA !!!");
   if (r === false) {
     return false;
@@ -52,6 +183,137 @@ function weatherClear(id) {
   serverAction(id, "wea");
 }
 
+function transferOwnership(id) {
+  var name = serverCache[id].name;
+  var playername = prompt("Transfer " + name + " to which player?");
+  if (playername === null || playername == "") {
+    return false;
+  }
+  var keep = confirm("Keep yourself on as a co-owner of " + name + "?");
+  var data = new FormData();
+  data.append("playername", playername);
+  data.append("keep", keep);
+  serverAction(id, "own", data);
+}
+
+function coOwnerAdd(serverID) {
+  var playername = prompt("Name of the player to make co-owner:");
+  if (playername !== null && playername != "") {
+    var data = new FormData();
+    data.append("playername", playername);
+    serverAction(serverID, "coa", data);
+  }
+}
+
+function coOwnerRemove(serverID) {
+  var coowners = serverCache[serverID].coowners;
+  var playername = prompt("Name of the co-owner to remove:\n\n" + coowners.join("\n"));
+  if (playername !== null && playername != "") {
+    var data = new FormData();
+    data.append("playername", playername);
+    serverAction(serverID, "cor", data);
+  }
+}
+
+// Roles
+function manageRoles(id) {
+  fetchRoles(id, function () {
+    new bootstrap.Modal(document.getElementById("rolesModal")).show();
+  });
+}
+
+function fetchRoles(id, done) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshRoles(id, JSON.parse(this.responseText).roles);
+      if (done) {
+        done();
+      }
+    }
+  };
+  xhttp.open("GET", "/api/v1/server/" + id + "/roles", true);
+  xhttp.send();
+}
+
+function refreshRoles(id, roles) {
+  var perms = serverCache[id].perms;
+  document.getElementById("roles_uuid").value = id;
+
+  var grants = document.getElementById("rolesGrants");
+  grants.innerHTML = "";
+  for (const g of roles.grants) {
+    var row = document.createElement("tr");
+    row.innerHTML = `<td>` + g.name + `</td><td>` + g.role + `</td><td>` +
+      (perms.rol.allowed ? `<a title="revoke" href="#" onClick="revokeRole('` + id + `', '` + g.name + `')"><i class="bi-x-circle text-danger"></i></a>` : ``) +
+      `</td>`;
+    grants.appendChild(row);
+  }
+
+  var select = document.getElementById("rolesSelect");
+  select.innerHTML = "";
+  for (const name of Object.keys(roles.builtin).concat(Object.keys(roles.custom))) {
+    var opt = document.createElement("option");
+    opt.value = name;
+    opt.innerText = name;
+    select.appendChild(opt);
+  }
+  select.form.classList.toggle("hidden", !perms.rol.allowed);
+
+  var custom = [];
+  for (const [name, actions] of Object.entries(roles.custom)) {
+    custom.push("<strong>" + name + "</strong>: " + actions.map(function (a) { return roles.actions[a]; }).join(", "));
+  }
+  document.getElementById("rolesCustom").innerHTML = custom.length ? custom.join("<br>") : "None defined.";
+
+  var actions = document.getElementById("rolesActions");
+  actions.innerHTML = "";
+  for (const [key, name] of Object.entries(roles.actions)) {
+    actions.innerHTML += `<div class="form-check form-check-inline">
+      <input class="form-check-input" type="checkbox" name="actions" value="` + key + `" id="action_` + key + `">
+      <label class="form-check-label" for="action_` + key + `">` + name + `</label>
+    </div>`;
+  }
+  document.getElementById("defineRoleForm").classList.toggle("hidden", !perms.rdf.allowed);
+}
+
+function revokeRole(id, playername) {
+  var data = new FormData();
+  data.append("playername", playername);
+  roleAction(id, "rol", data);
+}
+
+function submitGrant(form) {
+  roleAction(form.uuid.value, "rol", new FormData(form));
+  form.playername.value = "";
+  return false;
+}
+
+function submitRole(form) {
+  roleAction(document.getElementById("roles_uuid").value, "rdf", new FormData(form));
+  form.reset();
+  return false;
+}
+
+function roleAction(id, action, formdata) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Roles updated";
+        toastList[0].show(); // successToast
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+      fetchRoles(id);
+    }
+  };
+  xhttp.open("POST", "/api/v1/server/" + id + "/" + action, true);
+  xhttp.send(formdata);
+}
+
 function whitelistAdd(serverID) {
   var playername = prompt("Name of the player to whitelist:");
   if (playername != "") {
@@ -67,6 +329,9 @@ function serverAction(id, action, formdata) {
     if (this.readyState == 4) {
       var replyObj = JSON.parse(this.responseText);
       if (this.status == 200) {
+        if (replyObj.hasOwnProperty("job")) {
+          watchJob(replyObj.job.id);
+        }
         document.getElementById('successToastBody').innerText = "Action successful";
         toastList[0].show(); // successToast
 
@@ -89,6 +354,72 @@ function serverAction(id, action, formdata) {
   }
 }
 
+// Jobs (long-running actions), watched ids are kept in localStorage to survive page reloads
+function watchedJobs() {
+  return JSON.parse(localStorage.getItem("jobs") || "[]");
+}
+
+function watchJob(id) {
+  var ids = watchedJobs();
+  if (!ids.includes(id)) {
+    ids.push(id);
+    localStorage.setItem("jobs", JSON.stringify(ids));
+  }
+  setTimeout(fetchJob, 2000, id);
+}
+
+function unwatchJob(id) {
+  var ids = watchedJobs().filter(function (item) { return item != id; });
+  localStorage.setItem("jobs", JSON.stringify(ids));
+}
+
+function fetchJob(id) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      if (this.status != 200) {
+        unwatchJob(id);
+        return
+      }
+
+      var job = JSON.parse(this.responseText).job;
+      if (job.state == "done") {
+        unwatchJob(id);
+        document.getElementById('successToastBody').innerText = "Finished " + job.kind;
+        toastList[0].show(); // successToast
+        refreshServersPage();
+      } else if (job.state == "failed") {
+        unwatchJob(id);
+        document.getElementById('dangerToastBody').innerText = "Error: " + job.error;
+        toastList[1].show(); // dangerToast
+        refreshServersPage();
+      } else {
+        if (job.progress.length > 0) {
+          document.getElementById('warningToastBody').innerText = job.progress[job.progress.length - 1].text;
+          toastList[3].show(); // warningToast
+        }
+        setTimeout(fetchJob, 2000, id);
+      }
+    }
+  };
+  xhttp.open("GET", "/api/v1/jobs/" + id, true);
+  xhttp.send();
+}
+
+function refreshServersPage() {
+  if (document.getElementById("noservers") !== null) {
+    fetchServers();
+  }
+}
+
+function resumeJobs() {
+  for (const id of watchedJobs()) {
+    fetchJob(id);
+  }
+}
+
+window.addEventListener("load", resumeJobs);
+
 // Modal Actions
 function closeModal(id) {
   var myModalEl = document.getElementById(id);
@@ -115,6 +446,7 @@ function submitForm(loc, form) {
         toastList[0].show(); // successToast
 
         if (loc == "/api/v1/create") {
+          watchJob(replyObj.job.id);
           toastList[3]._config.delay = 5000;
           closeModal('newServerModal');
           if (replyObj.page == "servers") {
@@ -122,15 +454,6 @@ function submitForm(loc, form) {
           } else {
             document.location.href = "/view/servers";
           }
-        } else if (loc == "/api/v1/login") {
-          document.getElementById('newServerIcon').classList.remove("hidden");
-          document.getElementById('logOutButton').classList.remove("hidden");
-          document.getElementById('logInButton').classList.add("hidden");
-          document.getElementById('playerName').innerText = replyObj.playername;
-          closeModal('logInModal');
-          if (replyObj.page == "servers") {
-            fetchServers();
-          }
         }
       } else {
         document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
@@ -143,6 +466,143 @@ function submitForm(loc, form) {
   return false;
 }
 
+// Quota (shown on the new server form)
+function fetchQuota() {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshQuota(JSON.parse(this.responseText).quota);
+    }
+  };
+  xhttp.open("GET", "/api/v1/quota", true);
+  xhttp.send();
+}
+
+function refreshQuota(quota) {
+  var info = document.getElementById("quotaInfo");
+  var submit = document.getElementById("newServerSubmit");
+  submit.disabled = !quota.allowed;
+
+  var lines = [];
+  if (!quota.allowed) {
+    lines.push("You are not allowed to create servers.");
+  } else if (!quota.exempt) {
+    if (quota.maxservers > 0) {
+      lines.push("Servers: " + quota.usage.servers + " of " + quota.maxservers);
+    }
+    if (quota.maxmemory > 0) {
+      lines.push("Memory: " + bytesToString(quota.usage.memory) + " of " + bytesToString(quota.maxmemory));
+    }
+    if (quota.maxdisk > 0) {
+      lines.push("Disk: " + bytesToString(quota.usage.disk) + " of " + bytesToString(quota.maxdisk));
+    }
+  }
+  info.innerHTML = lines.join("<br>");
+  info.classList.toggle("hidden", lines.length == 0);
+}
+
+// Login providers (only the enabled ones are shown on the login form)
+function fetchLoginProviders() {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      var kinds = { "password": "loginPassword", "redirect": "loginOIDC", "device": "deviceLoginStart" };
+      for (const id of Object.values(kinds)) {
+        document.getElementById(id).classList.add("hidden");
+      }
+      for (const p of JSON.parse(this.responseText).providers) {
+        document.getElementById(kinds[p.kind]).classList.remove("hidden");
+        if (p.kind == "redirect") {
+          document.getElementById("loginOIDCButton").innerText = "Log in with " + p.label;
+        }
+      }
+    }
+  };
+  xhttp.open("GET", "/api/v1/login/providers", true);
+  xhttp.send();
+}
+
+// Login (local username and password)
+function submitPasswordLogin(form, page) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        form.reset();
+        loggedIn(replyObj.playername, page);
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+    }
+  };
+  xhttp.open("POST", "/api/v1/login/local", true);
+  xhttp.send(new FormData(form));
+  return false;
+}
+
+// Login (Microsoft device code)
+function startDeviceLogin(page) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        var login = replyObj.login;
+        document.getElementById("deviceLoginURI").innerText = login.verificationuri;
+        document.getElementById("deviceLoginURI").href = login.verificationuri;
+        document.getElementById("deviceLoginUserCode").innerText = login.usercode;
+        document.getElementById("deviceLoginStart").classList.add("hidden");
+        document.getElementById("deviceLoginCode").classList.remove("hidden");
+        setTimeout(function () { pollDeviceLogin(login.id, page); }, 5000);
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+    }
+  };
+  xhttp.open("POST", "/api/v1/login/device", true);
+  xhttp.send();
+}
+
+function pollDeviceLogin(id, page) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200 && replyObj.state == "pending") {
+        setTimeout(function () { pollDeviceLogin(id, page); }, 5000);
+        return;
+      }
+
+      document.getElementById("deviceLoginStart").classList.remove("hidden");
+      document.getElementById("deviceLoginCode").classList.add("hidden");
+      if (this.status == 200 && replyObj.state == "done") {
+        loggedIn(replyObj.playername, page);
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+    }
+  };
+  xhttp.open("GET", "/api/v1/login/device/" + id, true);
+  xhttp.send();
+}
+
+function loggedIn(playername, page) {
+  document.getElementById('successToastBody').innerText = "Success";
+  toastList[0].show(); // successToast
+  document.getElementById('newServerIcon').classList.remove("hidden");
+  document.getElementById('logOutButton').classList.remove("hidden");
+  document.getElementById('logInButton').classList.add("hidden");
+  document.getElementById('playerName').innerText = playername;
+  closeModal('logInModal');
+  if (page == "servers") {
+    fetchServers();
+  }
+}
+
 // Logout
 function logout() {
   var xhttp = new XMLHttpRequest();
@@ -167,6 +627,162 @@ function logout() {
   return false;
 }
 
+// Sessions
+function fetchSessions() {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshSessions(JSON.parse(this.responseText).sessions);
+    }
+  };
+  xhttp.open("GET", "/api/v1/sessions", true);
+  xhttp.send();
+}
+
+function refreshSessions(sessions) {
+  var rows = document.getElementById("sessionsList");
+  rows.innerHTML = "";
+  for (const s of sessions) {
+    var action = s.current ? `<span class="badge bg-success">this one</span>` :
+      `<a title="revoke" href="#" onClick="revokeSession('` + s.id + `')"><i class="bi-x-circle text-danger"></i></a>`;
+    var row = document.createElement("tr");
+    row.innerHTML = `<td>` + new Date(s.created).toLocaleString() + `</td><td>` + new Date(s.lastseen).toLocaleString() + `</td>
+      <td></td><td class="text-truncate" style="max-width: 200px;"></td><td>` + action + `</td>`;
+    // the browser sent these, so they're shown as text only
+    row.cells[2].innerText = s.ip;
+    row.cells[3].innerText = s.useragent;
+    rows.appendChild(row);
+  }
+}
+
+function revokeSession(id) {
+  var data = new FormData();
+  data.append("id", id);
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Session revoked";
+        toastList[0].show(); // successToast
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+      refreshSessions(replyObj.sessions);
+    }
+  };
+  xhttp.open("POST", "/api/v1/sessions/revoke", true);
+  xhttp.send(data);
+}
+
+function logoutEverywhere() {
+  if (!confirm("Log out of every browser and device, this one included?")) {
+    return false;
+  }
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      if (this.status == 200) {
+        document.location.href = "/";
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + JSON.parse(this.responseText).error;
+        toastList[1].show(); // dangerToast
+      }
+    }
+  };
+  xhttp.open("POST", "/api/v1/logout/all", true);
+  xhttp.send();
+}
+
+// API tokens
+function fetchTokens() {
+  document.getElementById("newTokenAlert").classList.add("hidden");
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshTokens(JSON.parse(this.responseText).tokens);
+    }
+  };
+  xhttp.open("GET", "/api/v1/tokens", true);
+  xhttp.send();
+}
+
+function refreshTokens(tokens) {
+  var rows = document.getElementById("tokensList");
+  rows.innerHTML = "";
+  for (const t of tokens) {
+    var scope = [];
+    if (t.readonly) {
+      scope.push("read-only");
+    }
+    scope.push(t.servers && t.servers.length ? t.servers.length + " server(s)" : "all servers");
+    scope.push(t.actions && t.actions.length ? t.actions.join(", ") : "all actions");
+    var row = document.createElement("tr");
+    row.innerHTML = `<td></td><td>` + scope.join("; ") + `</td>
+      <td>` + (t.expires ? new Date(t.expires).toLocaleDateString() : "never") + `</td>
+      <td></td>
+      <td><a title="revoke" href="#" onClick="revokeToken('` + t.id + `')"><i class="bi-x-circle text-danger"></i></a></td>`;
+    row.cells[0].innerText = t.name;
+    row.cells[3].innerText = t.lastused ? new Date(t.lastused).toLocaleString() + " from " + t.lastip : "never";
+    rows.appendChild(row);
+  }
+}
+
+function createToken() {
+  var data = new FormData(document.getElementById("tokenForm"));
+  for (const field of ["servers", "actions"]) {
+    var id = "token" + field.charAt(0).toUpperCase() + field.slice(1);
+    for (const v of document.getElementById(id).value.split(",")) {
+      if (v.trim() != "") {
+        data.append(field, v.trim());
+      }
+    }
+  }
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById("newToken").innerText = replyObj.token;
+        document.getElementById("newTokenAlert").classList.remove("hidden");
+        document.getElementById("tokenForm").reset();
+        refreshTokens(replyObj.tokens);
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+    }
+  };
+  xhttp.open("POST", "/api/v1/tokens", true);
+  xhttp.send(data);
+  return false;
+}
+
+function revokeToken(id) {
+  if (!confirm("Revoke this token? Scripts using it will stop working.")) {
+    return false;
+  }
+  var data = new FormData();
+  data.append("id", id);
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Token revoked";
+        toastList[0].show(); // successToast
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+      refreshTokens(replyObj.tokens);
+    }
+  };
+  xhttp.open("POST", "/api/v1/tokens/revoke", true);
+  xhttp.send(data);
+}
+
 // Releases
 function fetchReleases() {
   var xhttp = new XMLHttpRequest();
@@ -260,6 +876,36 @@ function fetchServers() {
   xhttp.send();
 }
 
+// Live updates via server-sent events, falling back to polling
+function watchServerEvents() {
+  if (typeof (EventSource) === "undefined") {
+    setInterval(fetchServers, 5000);
+    return
+  }
+
+  var source = new EventSource("/api/v1/events");
+  var serverEvents = ["server_added", "server_updated", "server_removed", "server_started", "server_stopped",
+    "player_joined", "player_left", "backup_completed"];
+  for (const name of serverEvents) {
+    source.addEventListener(name, function (e) {
+      var data = JSON.parse(e.data);
+      if (data.removed === true) {
+        var card = document.getElementById(data.event.serverid);
+        if (card !== null) {
+          card.parentNode.removeChild(card);
+        }
+      } else if (data.hasOwnProperty("server")) {
+        document.getElementById("noservers").classList.add("hidden");
+        refreshServerCard(data.server);
+      }
+    });
+  }
+  source.onerror = function () {
+    // the browser reconnects on its own, catch up on anything missed in the meantime
+    fetchServers();
+  };
+}
+
 function refreshServers(data) {
   if (data.hasOwnProperty("servers")) {
     var entries = Object.entries(data.servers);
@@ -313,13 +959,43 @@ function newServerCard(item) {
                 <i class="bi-filter-square text-primary"></i> Backup
               </a>
             </li>
+            <li>
+              <a id="edt_`+ item.uuid + `" title="edit" href="#" class="dropdown-item disabled" onClick="editServer('` + item.uuid + `')">
+                <i class="bi-pencil-square text-primary"></i> Edit Details
+              </a>
+            </li>
+            <li>
+              <a id="roles_`+ item.uuid + `" title="roles" href="#" class="dropdown-item disabled" onClick="manageRoles('` + item.uuid + `')">
+                <i class="bi-people text-primary"></i> Roles
+              </a>
+            </li>
+            <li>
+              <a id="coa_`+ item.uuid + `" title="add co-owner" href="#" class="dropdown-item disabled" onClick="coOwnerAdd('` + item.uuid + `')">
+                <i class="bi-person-plus text-primary"></i> Add Co-Owner
+              </a>
+            </li>
+            <li>
+              <a id="cor_`+ item.uuid + `" title="remove co-owner" href="#" class="dropdown-item disabled" onClick="coOwnerRemove('` + item.uuid + `')">
+                <i class="bi-person-dash text-warning"></i> Remove Co-Owner
+              </a>
+            </li>
+            <li>
+              <a id="own_`+ item.uuid + `" title="transfer ownership" href="#" class="dropdown-item disabled" onClick="transferOwnership('` + item.uuid + `')">
+                <i class="bi-arrow-left-right text-warning"></i> Transfer Ownership
+              </a>
+            </li>
+            <li>
+              <a id="cln_`+ item.uuid + `" title="clone" href="#" class="dropdown-item disabled" onClick="cloneServer('` + item.uuid + `')">
+                <i class="bi-files text-primary"></i> Clone
+              </a>
+            </li>
             <li>
               <a id="sav_`+ item.uuid + `" title="save" href="#" class="dropdown-item disabled" onClick="saveServer('` + item.uuid + `')">
                 <i class="bi-save2 text-success"></i> Save
               </a>
             </li>
             <li>
-              <a id="rgn_`+ item.uuid + `" title="regen" href="#" class="dropdown-item disabled" onClick="regenServer('` + item.name + `', '` + item.uuid + `')">
+              <a id="rgn_`+ item.uuid + `" title="regen" href="#" class="dropdown-item disabled" onClick="regenServer('` + item.uuid + `')">
                 <i class="bi-card-image text-warning"></i> REGEN
               </a>
             </li>
@@ -334,7 +1010,7 @@ function newServerCard(item) {
               </a>
             </li>
             <li>
-              <a id="del_`+ item.uuid + `" title="delete" href="#" class="dropdown-item disabled" onClick="deleteServer('` + item.name + `', '` + item.uuid + `')">
+              <a id="del_`+ item.uuid + `" title="delete" href="#" class="dropdown-item disabled" onClick="deleteServer('` + item.uuid + `')">
                 <i class="bi-trash text-black"></i> DELETE
               </a>
             </li>
@@ -350,14 +1026,16 @@ function newServerCard(item) {
                 <div class="text-center">
                   <div class="serverName">
                     <h1 id="name_`+ item.uuid + `">
-                      `+ item.name + `
+                      `+ escapeHTML(item.name) + `
                     </h1>
                     <span id="address_`+ item.uuid + `" class="text-success">` + hostname + ":" + item.port + `</span> 
                   </div>
                   <h4 class="serverState">
                     <span id="running_`+ item.uuid + `" class="text-success">` + runningToString(item.running) + `</span>
                   </h4>
-                  <h4 id="motd_`+ item.uuid + `" class="serverMOTD">` + item.motd + `</h4>
+                  <h4 id="motd_`+ item.uuid + `" class="serverMOTD">` + escapeHTML(item.motd) + `</h4>
+                  <div id="description_`+ item.uuid + `" class="text-muted">` + escapeHTML(item.description) + `</div>
+                  <div id="tags_`+ item.uuid + `">` + tagsToBadges(item.tags) + `</div>
                 </div>
               </div>
             </div>
@@ -366,7 +1044,7 @@ function newServerCard(item) {
             <div class="card-body bg-light">
               <div class="servercard">
                 <div class="text-center">
-                  <strong>Online Players:</strong><br />
+                  <strong>Online Players:</strong> <span id="count_`+ item.uuid + `">` + item.online + ` / ` + item.maxplayers + `</span><br />
                   <span id="players_`+ item.uuid + `">` + listToVertical(item.players) + `</span>
                 </div>
               </div>
@@ -377,15 +1055,21 @@ function newServerCard(item) {
               <div class="card-text servercard">
                 <div class="text-center">
                   <p class="">
-                    <strong>Game Mode:</strong> `+ item.gamemode + `<br>
-                    <strong>World Type:</strong> `+ item.worldtype + `<br>
-                    <strong>Seed:</strong> `+ item.seed + `<br>
+                    <strong>Version:</strong> `+ escapeHTML(item.version) + `<br>
+                    <strong>Latency:</strong> `+ item.latency + ` ms<br>
+                    <strong>Game Mode:</strong> `+ escapeHTML(item.gamemode) + `<br>
+                    <strong>Map:</strong> `+ escapeHTML(item.map) + `<br>
+                    <strong>Plugins:</strong> `+ (item.plugins === null ? "" : escapeHTML(item.plugins.join(", "))) + `<br>
+                    <strong>World Type:</strong> `+ escapeHTML(item.worldtype) + `<br>
+                    <strong>Seed:</strong> `+ escapeHTML(item.seed) + `<br>
                     <strong>Whitelist On:</strong> `+ item.whitelistenabled + `<br>
-                    <strong>Hardcore:</strong> `+ item.hardcore + `<br>
-                    <strong>PVP:</strong> `+ item.pvp + `<br>
+                    <strong>Hardcore:</strong> `+ escapeHTML(item.hardcore) + `<br>
+                    <strong>PVP:</strong> `+ escapeHTML(item.pvp) + `<br>
                     <strong>Autostart:</strong> `+ item.autostart + `<br>
-                    <strong>Ops:</strong> `+ item.ops + `<br>
-                    <strong>Whitelisted:</strong> `+ item.whitelist + `<br>
+                    <strong>Owner:</strong> `+ escapeHTML(item.owner) + `<br>
+                    <strong>Co-Owners:</strong> `+ escapeHTML(item.coowners.join(", ")) + `<br>
+                    <strong>Ops:</strong> `+ escapeHTML(item.ops) + `<br>
+                    <strong>Whitelisted:</strong> `+ escapeHTML(item.whitelist) + `<br>
                   </p>
                 </div>
               </div>
@@ -435,24 +1119,34 @@ function newServerCard(item) {
 }
 
 function refreshServerCard(serverData) {
+  serverCache[serverData.uuid] = serverData;
   var svr = document.getElementById(serverData.uuid);
   if (svr === null) {
     newServerCard(serverData);
     return
   }
-  var props = ["address", "flavor", "motd", "name", "online", "players", "release", "running"];
+  var props = ["address", "count", "description", "flavor", "motd", "name", "online", "players", "release", "running", "tags"];
   for (var i = 0; i < props.length; i++) {
     var ele = document.getElementById(props[i] + "_" + serverData.uuid);
 
     var val = "";
     if (props[i] === "online") {
       val = countNonEmpty(serverData.players);
+    } else if (props[i] == "count") {
+      val = serverData.online + " / " + serverData.maxplayers;
     } else if (props[i] == "address") {
       val = hostname + ":" + serverData.port
     } else if (props[i] == "running") {
       val = runningToString(serverData.running);
     } else if (props[i] == "players") {
       val = listToVertical(serverData.players);
+    } else if (props[i] == "tags") {
+      val = tagsToBadges(serverData.tags);
+    } else if (props[i] == "description" || props[i] == "motd" || props[i] == "name") {
+      if (ele.textContent.trim() != serverData[props[i]]) {
+        ele.textContent = serverData[props[i]];
+      }
+      continue;
     } else {
       val = serverData[props[i]];
 
@@ -477,7 +1171,7 @@ function refreshServerCard(serverData) {
 function updateCardActionButtons(serverData) {
   const perms = serverData.perms;
   for (const perm in perms) {
-    if (perm == "upg") {
+    if (perm == "upg" || document.getElementById(perm + "_" + serverData.uuid) === null) {
       continue;
     }
     document.getElementById(perm + "_" + serverData.uuid).classList.add("disabled");
@@ -490,6 +1184,326 @@ function updateCardActionButtons(serverData) {
   }
 }
 
+// Trash
+function fetchTrash() {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshTrash(JSON.parse(this.responseText));
+    }
+  };
+  xhttp.open("GET", "/api/v1/trash", true);
+  xhttp.send();
+}
+
+function refreshTrash(data) {
+  var rows = document.getElementById("trashRows");
+  rows.innerHTML = "";
+  if (!data.hasOwnProperty("trash") || data.trash.length == 0) {
+    document.getElementById("trashTable").classList.add("hidden");
+    document.getElementById("notrash").classList.remove("hidden");
+    return
+  }
+
+  document.getElementById("notrash").classList.add("hidden");
+  document.getElementById("trashTable").classList.remove("hidden");
+  for (const item of data.trash) {
+    var row = document.createElement("tr");
+    row.innerHTML = `
+      <td>` + escapeHTML(item.name) + `</td>
+      <td>` + item.flavor + `</td>
+      <td>` + item.release + `</td>
+      <td>` + new Date(item.deletedat).toLocaleString() + `</td>
+      <td>` + (item.purgeat ? new Date(item.purgeat).toLocaleString() : "never") + `</td>
+      <td>
+        <a title="restore" href="#"><i class="bi-arrow-counterclockwise text-success"></i></a>
+        <a title="purge" href="#"><i class="bi-trash text-danger"></i></a>
+      </td>
+    `;
+    const id = item.uuid, name = item.name;
+    row.querySelector('[title="restore"]').addEventListener("click", function () { undeleteServer(id); });
+    row.querySelector('[title="purge"]').addEventListener("click", function () { purgeServer(name, id); });
+    rows.appendChild(row);
+  }
+}
+
+// Admin
+function fetchAdmin() {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshAdminServers(JSON.parse(this.responseText).servers);
+    }
+  };
+  xhttp.open("GET", "/api/v1/admin/servers", true);
+  xhttp.send();
+
+  var xhttp2 = new XMLHttpRequest();
+  xhttp2.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshAdmins(JSON.parse(this.responseText).admins);
+    }
+  };
+  xhttp2.open("GET", "/api/v1/admin/admins", true);
+  xhttp2.send();
+
+  var xhttp3 = new XMLHttpRequest();
+  xhttp3.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshAccounts(JSON.parse(this.responseText));
+    }
+  };
+  xhttp3.open("GET", "/api/v1/admin/accounts", true);
+  xhttp3.send();
+}
+
+function refreshAdminServers(servers) {
+  var rows = document.getElementById("adminServers");
+  rows.innerHTML = "";
+  for (const item of servers) {
+    var state = item.deleted ? "deleted " + new Date(item.deletedat).toLocaleDateString() : runningToString(item.running);
+    var actions = "";
+    if (item.deleted) {
+      actions = `<a title="restore" href="#"><i class="bi-arrow-counterclockwise text-success"></i></a>
+        <a title="purge" href="#"><i class="bi-trash text-danger"></i></a>`;
+    }
+    var row = document.createElement("tr");
+    row.innerHTML = `
+      <td>` + escapeHTML(item.name) + `</td>
+      <td>` + escapeHTML(item.owner + (item.coowners.length ? " (+ " + item.coowners.join(", ") + ")" : "")) + `</td>
+      <td>` + item.flavor + `</td>
+      <td>` + item.release + `</td>
+      <td>` + item.port + `</td>
+      <td>` + state + `</td>
+      <td>` + actions + `</td>
+    `;
+    if (item.deleted) {
+      const id = item.uuid, name = item.name;
+      row.querySelector('[title="restore"]').addEventListener("click", function () { adminTrashAction(id, "und"); });
+      row.querySelector('[title="purge"]').addEventListener("click", function () { adminPurge(name, id); });
+    }
+    rows.appendChild(row);
+  }
+}
+
+function refreshAdmins(admins) {
+  var rows = document.getElementById("adminAdmins");
+  rows.innerHTML = "";
+  for (const a of admins) {
+    var row = document.createElement("tr");
+    row.innerHTML = `<td>` + escapeHTML(a.name) + `</td><td>
+      <a title="remove" href="#"><i class="bi-x-circle text-danger"></i></a></td>`;
+    const name = a.name;
+    row.querySelector('[title="remove"]').addEventListener("click", function () { removeAdmin(name); });
+    rows.appendChild(row);
+  }
+}
+
+function adminTrashAction(id, action, formdata) {
+  trashAction(id, action, formdata);
+  setTimeout(fetchAdmin, 1000);
+}
+
+function adminPurge(name, id) {
+  var confirmName = prompt("Permanently purge " + name + "?\n\nTHIS CANNOT BE UNDONE !!!\n\nType the server name to confirm:");
+  if (confirmName === null) {
+    return false;
+  }
+  var data = new FormData();
+  data.append("confirm", confirmName);
+  adminTrashAction(id, "prg", data);
+}
+
+function submitAdmin(form) {
+  adminEdit(new FormData(form));
+  form.reset();
+  return false;
+}
+
+function removeAdmin(name) {
+  if (!confirm("Remove " + name + " from the admins?")) {
+    return false;
+  }
+  var data = new FormData();
+  data.append("playername", name);
+  data.append("remove", true);
+  adminEdit(data);
+}
+
+function adminEdit(formdata) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Admins updated";
+        toastList[0].show(); // successToast
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+      refreshAdmins(replyObj.admins);
+    }
+  };
+  xhttp.open("POST", "/api/v1/admin/admins", true);
+  xhttp.send(formdata);
+}
+
+function refreshAccounts(reply) {
+  var rows = document.getElementById("adminAccounts");
+  rows.innerHTML = "";
+  for (const a of reply.accounts) {
+    var row = document.createElement("tr");
+    row.innerHTML = `<td>` + escapeHTML(a.username) + `</td><td>` + (a.playername ? escapeHTML(a.playername) : "<em>not linked</em>") + `</td>
+      <td>` + new Date(a.changed).toLocaleDateString() + `</td><td>
+      <a title="remove" href="#"><i class="bi-x-circle text-danger"></i></a></td>`;
+    const username = a.username;
+    row.querySelector('[title="remove"]').addEventListener("click", function () { removeAccount(username); });
+    rows.appendChild(row);
+  }
+
+  rows = document.getElementById("adminLinks");
+  rows.innerHTML = "";
+  for (const l of reply.links) {
+    var row = document.createElement("tr");
+    row.innerHTML = `<td>` + escapeHTML(l.identity) + `</td><td>` + escapeHTML(l.playername) + `</td><td>
+      <a title="unlink" href="#"><i class="bi-x-circle text-danger"></i></a></td>`;
+    const identity = l.identity;
+    row.querySelector('[title="unlink"]').addEventListener("click", function () { removeLink(identity); });
+    rows.appendChild(row);
+  }
+}
+
+function submitAccount(form) {
+  accountEdit("/api/v1/admin/accounts", new FormData(form));
+  form.reset();
+  return false;
+}
+
+function removeAccount(username) {
+  if (!confirm("Remove the local account " + username + "?")) {
+    return false;
+  }
+  var data = new FormData();
+  data.append("username", username);
+  data.append("remove", true);
+  accountEdit("/api/v1/admin/accounts", data);
+}
+
+function submitLink(form) {
+  accountEdit("/api/v1/admin/links", new FormData(form));
+  form.reset();
+  return false;
+}
+
+function removeLink(identity) {
+  if (!confirm("Unlink " + identity + "?")) {
+    return false;
+  }
+  var data = new FormData();
+  data.append("identity", identity);
+  data.append("remove", true);
+  accountEdit("/api/v1/admin/links", data);
+}
+
+function accountEdit(loc, formdata) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4) {
+      var replyObj = JSON.parse(this.responseText);
+      if (this.status == 200) {
+        document.getElementById('successToastBody').innerText = "Accounts updated";
+        toastList[0].show(); // successToast
+      } else {
+        document.getElementById('dangerToastBody').innerText = "Error: " + replyObj.error;
+        toastList[1].show(); // dangerToast
+      }
+      refreshAccounts(replyObj);
+    }
+  };
+  xhttp.open("POST", loc, true);
+  xhttp.send(formdata);
+}
+
+// Metrics
+function fetchMetrics() {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      var data = JSON.parse(this.responseText);
+      if (!data.hasOwnProperty("servers")) {
+        return
+      }
+      for (const item of Object.values(data.servers)) {
+        if (item.perms.metrics.allowed === true) {
+          fetchServerMetrics(item);
+        }
+      }
+    }
+  };
+  xhttp.open("GET", "/api/v1/servers", true);
+  xhttp.send();
+}
+
+function fetchServerMetrics(item) {
+  var xhttp = new XMLHttpRequest();
+  xhttp.onreadystatechange = function () {
+    if (this.readyState == 4 && this.status == 200) {
+      refreshMetricsRow(item, JSON.parse(this.responseText).metrics);
+    }
+  };
+  xhttp.open("GET", "/api/v1/server/" + item.uuid + "/metrics", true);
+  xhttp.send();
+}
+
+function refreshMetricsRow(item, samples) {
+  var row = document.getElementById("metrics_" + item.uuid);
+  if (row === null) {
+    row = document.createElement("tr");
+    row.id = "metrics_" + item.uuid;
+    document.getElementById("metricsRows").appendChild(row);
+  }
+
+  var latest = { cpu: 0, rss: 0, disk: 0, tps: null, mspt: null, uptime: 0 };
+  if (samples.length > 0) {
+    latest = samples[samples.length - 1];
+  }
+  row.innerHTML = `
+    <td>` + escapeHTML(item.name) + `</td>
+    <td>` + latest.cpu.toFixed(1) + `%</td>
+    <td>` + bytesToString(latest.rss) + `</td>
+    <td>` + bytesToString(latest.disk) + `</td>
+    <td>` + tickValue(latest.tps, samples.length) + `</td>
+    <td>` + tickValue(latest.mspt, samples.length) + `</td>
+    <td>` + secondsToString(latest.uptime) + `</td>
+  `;
+}
+
+// tickValue shows a tps/mspt value, servers that can't report it get "n/a"
+function tickValue(val, samples) {
+  if (val !== null && val !== undefined) {
+    return val.toFixed(1);
+  }
+  return samples > 0 ? `<span class="text-muted" title="not supported by this server">n/a</span>` : "-";
+}
+
+function bytesToString(val) {
+  var units = ["B", "KB", "MB", "GB", "TB"];
+  var i = 0;
+  while (val >= 1024 && i < units.length - 1) {
+    val = val / 1024;
+    i++;
+  }
+  return val.toFixed(1) + " " + units[i]
+}
+
+function secondsToString(val) {
+  var d = Math.floor(val / 86400);
+  var h = Math.floor((val % 86400) / 3600);
+  var m = Math.floor((val % 3600) / 60);
+  return d + "d " + h + "h " + m + "m"
+}
+
 function countNonEmpty(arry) {
   if (arry === null) {
     return 0
@@ -513,13 +1527,27 @@ function runningToString(val) {
   return "Status Unknown"
 }
 
+function tagsToBadges(tags) {
+  var out = "";
+  for (const tag of tags) {
+    out += `<span class="badge bg-secondary me-1">` + escapeHTML(tag) + `</span>`;
+  }
+  return out;
+}
+
+// escapeHTML makes user supplied text safe to put into innerHTML
+function escapeHTML(text) {
+  return String(text).replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;").replace(/'/g, "&#39;");
+}
+
 function listToVertical(list) {
   var view = "";
   if (list === null) {
     return view
   }
   for (var i = 0; i < list.length; i++) {
-    view += list[i] + "<br />";
+    view += escapeHTML(list[i]) + "<br />";
   }
   return view
 }
@@ -2,6 +2,7 @@ package spigot
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,11 +13,12 @@ import (
 // Error: rename .storage/spigot/spigot-21w08b.jar .storage/jars/spigot/21w08b.jar: no such file or directory
 
 // Build will create a spigot build for the version specified
-func Build(release string) error {
+// BuildTools output is written to out (which may be nil)
+func Build(release string, out io.Writer) error {
 	var err error
 	for err == nil {
 		err = downloadBuildTools()
-		err = doBuild(release)
+		err = doBuild(release, out)
 		err = moveBuildArtifact(release)
 		break
 	}
@@ -40,9 +42,11 @@ func downloadBuildTools() error {
 	return err
 }
 
-func doBuild(release string) error {
+func doBuild(release string, out io.Writer) error {
 	cmd := exec.Command("java", "-jar", filepath.Join("../", "../", storage.JARDIR, "spigot", "BuildTools.jar"), "--rev", release)
 	cmd.Dir = filepath.Join(storage.SPIGOTBLDDIR)
+	cmd.Stdout = out
+	cmd.Stderr = out
 	err := cmd.Run()
 	if err != nil {
 		err = fmt.Errorf("ERROR building spigot release: %s", release)
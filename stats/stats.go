@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counter names
+const (
+	HTTPRequests  = "mcmanager_http_requests_total"
+	RconErrors    = "mcmanager_rcon_errors_total"
+	JarDownloads  = "mcmanager_jar_downloads_total"
+	LoginAttempts = "mcmanager_login_attempts_total"
+)
+
+var help = map[string]string{
+	HTTPRequests:  "HTTP requests handled, by route and status code.",
+	RconErrors:    "Failed rcon commands on servers that accepted the connection.",
+	JarDownloads:  "Server jar downloads, by flavor and result.",
+	LoginAttempts: "Login attempts, by result.",
+}
+
+var (
+	counters = make(map[string]map[string]float64)
+	mu       sync.Mutex
+)
+
+// Inc increments a counter, labels are given as name/value pairs
+func Inc(name string, labels ...string) {
+	var key = formatLabels(labels)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := counters[name]; !ok {
+		counters[name] = make(map[string]float64)
+	}
+	counters[name][key]++
+}
+
+// WriteCounters writes all counters in the Prometheus text exposition format
+func WriteCounters(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var names []string
+	for name := range help {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help[name])
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+		var keys []string
+		for key := range counters[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, key, counters[name][key])
+		}
+	}
+}
+
+// WriteGauge writes a single gauge sample (HELP and TYPE lines are written by WriteGaugeHeader)
+func WriteGauge(w io.Writer, name string, value float64, labels ...string) {
+	fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(labels), value)
+}
+
+// WriteGaugeHeader writes the HELP and TYPE lines for a gauge
+func WriteGaugeHeader(w io.Writer, name, text string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, text)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+}
+
+// formatLabels builds {name="value",...} from name/value pairs
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], escape(labels[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escape removes characters %q would escape differently than Prometheus expects
+func escape(val string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, val)
+}
@@ -0,0 +1,181 @@
+package status
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jlmeeker/mcmanager/server"
+	"github.com/jlmeeker/mcmanager/storage"
+)
+
+// BackupMaxAge is how old a server's last backup may be before it's reported as a problem
+var BackupMaxAge = 7 * 24 * time.Hour
+
+var (
+	started = time.Now()
+	version = "dev"
+
+	refreshes = make(map[string]Refresh)
+	mu        sync.Mutex
+)
+
+// Refresh is the outcome of a background refresh (releases, news...)
+type Refresh struct {
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError"`
+	LastErrorAt time.Time `json:"lastErrorAt"`
+}
+
+// ServerHealth is the health summary of a single server
+type ServerHealth struct {
+	UUID       string    `json:"uuid"`
+	Name       string    `json:"name"`
+	Running    bool      `json:"running"`
+	AutoStart  bool      `json:"autostart"`
+	Players    int       `json:"players"`
+	Latency    int64     `json:"latency"`
+	LastBackup time.Time `json:"lastBackup"`
+	Crashes    int       `json:"crashes"`
+	Healthy    bool      `json:"healthy"`
+	Problems   []string  `json:"problems"`
+}
+
+// Report is the full status of the manager, its host and its servers
+type Report struct {
+	Version      string             `json:"version"`
+	Started      time.Time          `json:"started"`
+	Uptime       string             `json:"uptime"`
+	StorageFree  uint64             `json:"storageFree"`
+	StorageTotal uint64             `json:"storageTotal"`
+	JarCacheSize int64              `json:"jarCacheSize"`
+	Git          bool               `json:"git"`
+	Java         map[string]bool    `json:"java"`
+	Refreshes    map[string]Refresh `json:"refreshes"`
+	Servers      []ServerHealth     `json:"servers"`
+	Limited      bool               `json:"limited"`
+}
+
+// SetVersion sets the version reported by the status page
+func SetVersion(v string) {
+	if v != "" {
+		version = v
+	}
+}
+
+// RecordRefresh records the outcome of a background refresh
+func RecordRefresh(name string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	r := refreshes[name]
+	if err == nil {
+		r.LastSuccess = time.Now()
+	} else {
+		r.LastError = err.Error()
+		r.LastErrorAt = time.Now()
+	}
+	refreshes[name] = r
+}
+
+// Get builds the current status report, servers are limited to those visible to playerName.
+// Without a player only the uptime is reported, host details are for logged in players.
+func Get(playerName string) Report {
+	var r = Report{
+		Started:   started,
+		Uptime:    formatDuration(time.Since(started)),
+		Java:      make(map[string]bool),
+		Refreshes: make(map[string]Refresh),
+		Servers:   []ServerHealth{},
+	}
+	if playerName == "" {
+		r.Limited = true
+		return r
+	}
+
+	r.Version = version
+	r.JarCacheSize = storage.DirSize(storage.JARDIR)
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(storage.STORAGEDIR, &fs); err == nil {
+		r.StorageFree = fs.Bavail * uint64(fs.Bsize)
+		r.StorageTotal = fs.Blocks * uint64(fs.Bsize)
+	}
+
+	r.Git = commandAvailable("git")
+	for _, java := range []string{server.Java16, server.Java8} {
+		if java != "" {
+			r.Java[java] = commandAvailable(java)
+		}
+	}
+
+	mu.Lock()
+	for name, ref := range refreshes {
+		r.Refreshes[name] = ref
+	}
+	mu.Unlock()
+
+	for _, s := range server.ServersWithPlayer(playerName) {
+		r.Servers = append(r.Servers, health(s))
+	}
+	sort.Slice(r.Servers, func(i, j int) bool {
+		return r.Servers[i].Name < r.Servers[j].Name
+	})
+
+	return r
+}
+
+// health checks a single server
+func health(s *server.Server) ServerHealth {
+	var h = ServerHealth{
+		UUID:      s.UUID,
+		Name:      s.Name,
+		AutoStart: s.AutoStart,
+		Crashes:   s.CrashCount(),
+		Problems:  []string{},
+	}
+
+	if st, err := s.Ping(); err == nil {
+		h.Running = true
+		h.Players = st.Players.Online
+		h.Latency = st.Latency.Milliseconds()
+	} else {
+		h.Running = s.IsRunning()
+		if h.Running {
+			h.Problems = append(h.Problems, "not answering status pings")
+		}
+	}
+
+	if h.AutoStart && !h.Running {
+		h.Problems = append(h.Problems, "autostart server is not running")
+	}
+
+	if lb, err := storage.LastBackup(s.UUID); err == nil {
+		h.LastBackup = lb
+		if time.Since(lb) > BackupMaxAge {
+			h.Problems = append(h.Problems, "no backup in "+formatDuration(time.Since(lb)))
+		}
+	} else {
+		h.Problems = append(h.Problems, "unable to read backups")
+	}
+
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
+
+func commandAvailable(cmd string) bool {
+	_, err := exec.LookPath(cmd)
+	return err == nil
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	return fmt.Sprintf("%dd %dh %dm", days, hours, d/time.Minute)
+}
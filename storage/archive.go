@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ArchiveServer writes a .tar.gz of the server directory into ARCHIVEDIR and returns its path
+func ArchiveServer(id string) (string, error) {
+	var srcDir = filepath.Join(SERVERDIR, id)
+	var dstPath = filepath.Join(ARCHIVEDIR, fmt.Sprintf("%s-%s.tar.gz", id, time.Now().Format("20060102-150405")))
+
+	dh, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, DEFAULTFILEPERM)
+	if err != nil {
+		return "", err
+	}
+	defer dh.Close()
+
+	gz := gzip.NewWriter(dh)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // skip symlinks, sockets etc.
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(filepath.Join(id, rel))
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		_, err = io.Copy(tw, fh)
+		return err
+	})
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(dstPath)
+		return "", err
+	}
+	return dstPath, nil
+}
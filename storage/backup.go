@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 // GITCONFIG is the default contents of the .git/gitconfig file
@@ -106,3 +109,23 @@ func GitCommit(serverID, message string) error {
 
 	return err
 }
+
+// LastBackup returns the time of the most recent backup commit
+func LastBackup(serverID string) (time.Time, error) {
+	if !gitAvailable() {
+		return time.Time{}, fmt.Errorf("git not available")
+	}
+
+	var cmd = exec.Command("git", "log", "-1", "--format=%ct")
+	cmd.Dir = filepath.Join(SERVERDIR, serverID)
+	out, err := cmd.Output()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cloneSkip are top-level entries of a server dir that are never copied into a clone
+var cloneSkip = []string{".git", "logs", "crash-reports"}
+
+var commitRE = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// CloneServer copies the working files of one server dir into a new one
+// the backup history (.git) and logs are left behind
+func CloneServer(srcID, dstID string) error {
+	var srcDir = filepath.Join(SERVERDIR, srcID)
+	var dstDir = filepath.Join(SERVERDIR, dstID)
+
+	if err := os.Mkdir(dstDir, DEFAULTDIRPERM); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if skipped(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var dst = filepath.Join(dstDir, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, DEFAULTDIRPERM)
+		case d.Type().IsRegular():
+			return copyFile(path, dst)
+		}
+		return nil // skip symlinks, sockets etc.
+	})
+}
+
+// CloneServerBackup creates a new server dir from the contents of a backup commit of another server
+func CloneServerBackup(srcID, commit, dstID string) error {
+	if !gitAvailable() {
+		return fmt.Errorf("git not available")
+	}
+	if !commitRE.MatchString(commit) {
+		return fmt.Errorf("invalid backup commit %q", commit)
+	}
+
+	var srcDir = filepath.Join(SERVERDIR, srcID)
+	var dstDir = filepath.Join(SERVERDIR, dstID)
+
+	var verify = exec.Command("git", "rev-parse", "--verify", "--quiet", commit+"^{commit}")
+	verify.Dir = srcDir
+	if err := verify.Run(); err != nil {
+		return fmt.Errorf("backup %s not found", commit)
+	}
+
+	if err := os.Mkdir(dstDir, DEFAULTDIRPERM); err != nil {
+		return err
+	}
+
+	var cmd = exec.Command("git", "archive", "--format=tar", commit)
+	cmd.Dir = srcDir
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	err = untar(out, dstDir)
+	if err != nil {
+		io.Copy(io.Discard, out)
+	}
+	if werr := cmd.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// Backup is a single backup commit of a server
+type Backup struct {
+	Commit  string `json:"commit"`
+	Time    int64  `json:"time"`
+	Message string `json:"message"`
+}
+
+// Backups lists the backup commits of a server, newest first
+func Backups(serverID string) ([]Backup, error) {
+	var backups = []Backup{}
+	if !gitAvailable() {
+		return backups, fmt.Errorf("git not available")
+	}
+
+	var cmd = exec.Command("git", "log", "--format=%h %ct %s")
+	cmd.Dir = filepath.Join(SERVERDIR, serverID)
+	out, err := cmd.Output()
+	if err != nil {
+		return backups, err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		ts, _ := strconv.ParseInt(fields[1], 10, 64)
+		backups = append(backups, Backup{Commit: fields[0], Time: ts, Message: fields[2]})
+	}
+	return backups, nil
+}
+
+// skipped reports whether a path relative to the server dir is excluded from clones
+func skipped(rel string) bool {
+	var top = strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	for _, s := range cloneSkip {
+		if top == s {
+			return true
+		}
+	}
+	return filepath.Base(rel) == "session.lock"
+}
+
+func copyFile(src, dst string) error {
+	sh, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sh.Close()
+
+	info, err := sh.Stat()
+	if err != nil {
+		return err
+	}
+
+	dh, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dh, sh)
+	if cerr := dh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// untar extracts regular files and dirs of a tar stream below dir
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var dst = filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(dst, filepath.Clean(dir)+string(os.PathSeparator)) || skipped(hdr.Name) {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, DEFAULTDIRPERM)
+		case tar.TypeReg:
+			err = os.MkdirAll(filepath.Dir(dst), DEFAULTDIRPERM)
+			if err == nil {
+				err = writeFrom(tr, dst, hdr.FileInfo().Mode().Perm())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeFrom(r io.Reader, dst string, perm fs.FileMode) error {
+	dh, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dh, r)
+	if cerr := dh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
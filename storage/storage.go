@@ -10,13 +10,18 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/jlmeeker/mcmanager/stats"
 )
 
 // Necesary storage directories
 var (
 	STORAGEDIR   string
+	ARCHIVEDIR   string
 	JARDIR       string
+	JOBDIR       string
 	SERVERDIR    string
+	SESSIONDIR   string
 	SPIGOTBLDDIR string
 )
 
@@ -29,15 +34,21 @@ var (
 // Prepare will create all the base storage directories
 func Prepare(sd string) error {
 	STORAGEDIR = sd
+	ARCHIVEDIR = filepath.Join(STORAGEDIR, "archives")
 	JARDIR = filepath.Join(STORAGEDIR, "jars")
+	JOBDIR = filepath.Join(STORAGEDIR, "jobs")
 	SERVERDIR = filepath.Join(STORAGEDIR, "servers")
+	SESSIONDIR = filepath.Join(STORAGEDIR, "sessions")
 	SPIGOTBLDDIR = filepath.Join(STORAGEDIR, "spigot")
 
 	var err error
 	for err == nil {
 		err = os.MkdirAll(STORAGEDIR, DEFAULTDIRPERM)
+		err = makeSubDir(STORAGEDIR, "archives")
 		err = makeSubDir(STORAGEDIR, "jars")
+		err = makeSubDir(STORAGEDIR, "jobs")
 		err = makeSubDir(STORAGEDIR, "servers")
+		err = makeSubDir(STORAGEDIR, "sessions")
 		err = makeSubDir(STORAGEDIR, "spigot")
 		break
 	}
@@ -98,6 +109,16 @@ func DownloadJar(flavor, release, jarURL string, force bool) error {
 		return nil
 	}
 
+	err := downloadJar(flavor, release, jarURL)
+	if err != nil {
+		stats.Inc(stats.JarDownloads, "flavor", flavor, "result", "error")
+	} else {
+		stats.Inc(stats.JarDownloads, "flavor", flavor, "result", "success")
+	}
+	return err
+}
+
+func downloadJar(flavor, release, jarURL string) error {
 	// no-op if alredy exists
 	err := makeSubDir(JARDIR, flavor)
 	if err != nil {
@@ -204,3 +225,20 @@ func EraseServerFile(id, fname string) error {
 
 	return err
 }
+
+// DirSize returns the total size of all regular files below dir
+func DirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}